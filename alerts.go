@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// alertRule fires when a metric from historyMetrics reaches threshold
+type alertRule struct {
+	metric    string
+	threshold float64
+
+	// Whether the last snapshot breached the threshold, and when the last
+	// firing alert was sent
+	firing   bool
+	lastSent time.Time
+}
+
+type Alert struct {
+	Hostname  string    `json:"hostname"`
+	Metric    string    `json:"metric"`
+	Status    string    `json:"status"` // firing or resolved
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// alertRules returns a rule for every threshold that is set
+func (app *application) alertRules() []*alertRule {
+	var rules []*alertRule
+	if app.config.alertMemPct > 0 {
+		rules = append(rules, &alertRule{metric: "memory.usedPercent", threshold: app.config.alertMemPct})
+	}
+	if app.config.alertLoad1 > 0 {
+		rules = append(rules, &alertRule{metric: "load1", threshold: app.config.alertLoad1})
+	}
+	return rules
+}
+
+// runAlerts checks every snapshot from the collector against the alert
+// thresholds until the server shuts down. A breach is posted to the webhook
+// when it starts and again every -alert-cooldown while it lasts, and a
+// resolved alert is posted once it clears.
+func (app *application) runAlerts() {
+	rules := app.alertRules()
+	if len(rules) == 0 {
+		return
+	}
+
+	updates, _ := app.collector.subscribe()
+	defer app.collector.unsubscribe(updates)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	for {
+		select {
+		case <-app.shutdown:
+			return
+		case rs := <-updates:
+			for _, rule := range rules {
+				app.evaluateAlert(client, rule, rs)
+			}
+		}
+	}
+}
+
+func (app *application) evaluateAlert(client *http.Client, rule *alertRule, rs *Resources) {
+	value := historyMetrics[rule.metric](rs)
+	breached := value >= rule.threshold
+	now := time.Now()
+
+	var status string
+	switch {
+	case breached && (!rule.firing || now.Sub(rule.lastSent) >= app.config.alertCooldown):
+		status = "firing"
+		rule.lastSent = now
+	case !breached && rule.firing:
+		status = "resolved"
+	}
+	rule.firing = breached
+
+	if status == "" {
+		return
+	}
+
+	alert := Alert{
+		Hostname:  rs.Hostname,
+		Metric:    rule.metric,
+		Status:    status,
+		Value:     value,
+		Threshold: rule.threshold,
+		Timestamp: now,
+	}
+
+	app.logger.Warn("alert", "metric", alert.Metric, "status", alert.Status, "value", alert.Value,
+		"threshold", alert.Threshold)
+
+	err := sendAlert(client, app.config.alertWebhook, alert)
+	if err != nil {
+		app.logger.Error("sending alert", "metric", alert.Metric, "error", err)
+	}
+}
+
+// sendAlert posts an alert to the webhook as JSON
+func sendAlert(client *http.Client, webhook string, alert Alert) error {
+	js, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(webhook, "application/json", bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
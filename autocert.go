@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// autocertManager returns a manager that obtains certificates for
+// -autocert-domain from Let's Encrypt and renews them before they expire, or
+// nil when -autocert-domain is not set. Certificates and the account key are
+// kept in -autocert-cache, so a restart does not request them again.
+func (app *application) autocertManager() *autocert.Manager {
+	domains := splitList(app.config.autocertDomain)
+	if len(domains) == 0 {
+		return nil
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(app.config.autocertCache),
+	}
+}
+
+// serveACMEChallenges answers Let's Encrypt's HTTP-01 challenges on port 80
+// and redirects every other request there to HTTPS on -port. The TLS-ALPN-01
+// challenge is answered by the main server, but only works when it listens
+// on port 443; this covers the other cases. Failing to bind port 80 is
+// logged rather than fatal for that reason.
+func (app *application) serveACMEChallenges(m *autocert.Manager) *http.Server {
+	srv := &http.Server{
+		Addr:         ":80",
+		Handler:      m.HTTPHandler(http.HandlerFunc(app.redirectToHTTPS)),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+
+	go func() {
+		err := srv.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			app.logger.Warn("serving ACME challenges", "addr", srv.Addr, "error", err)
+		}
+	}()
+
+	return srv
+}
+
+// redirectToHTTPS sends a plain HTTP request to the same URL over HTTPS on
+// -port. autocert's own fallback always redirects to port 443, which nothing
+// listens on unless -port is 443.
+func (app *application) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Use HTTPS", http.StatusBadRequest)
+		return
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if app.config.port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(app.config.port))
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusFound)
+}
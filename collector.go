@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// collector holds the most recent snapshot and the channels of everyone
+// waiting for the next one. A single goroutine, runCollector, gathers
+// snapshots and publishes them here, so the cost of reading the host does
+// not grow with the number of clients.
+//
+// Published snapshots are shared and must be treated as read-only; a client
+// that wants to narrow one down copies the struct first.
+type collector struct {
+	mu     sync.Mutex
+	latest *Resources
+	subs   map[chan *Resources]struct{}
+
+	// CPU times from the previous snapshot; only runCollector touches it
+	cpu cpuSampler
+}
+
+func newCollector() *collector {
+	return &collector{subs: make(map[chan *Resources]struct{})}
+}
+
+// subscribe returns a channel that receives every future snapshot, along
+// with the latest one, which is nil before the first collection finishes.
+// The channel holds a single snapshot: a subscriber that falls behind skips
+// straight to the newest.
+func (c *collector) subscribe() (chan *Resources, *Resources) {
+	ch := make(chan *Resources, 1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.subs[ch] = struct{}{}
+	return ch, c.latest
+}
+
+func (c *collector) unsubscribe(ch chan *Resources) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.subs, ch)
+}
+
+// publish stores rs as the latest snapshot and hands it to every subscriber
+// without waiting for any of them
+func (c *collector) publish(rs *Resources) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.latest = rs
+	for ch := range c.subs {
+		// Only publish sends on these channels, so once a stale snapshot
+		// is drained the send cannot block
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- rs
+	}
+}
+
+// peek returns the latest snapshot without waiting, or nil before the first
+// collection finishes
+func (c *collector) peek() *Resources {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.latest
+}
+
+// current returns the latest snapshot, waiting for the first collection to
+// finish if there is none yet
+func (c *collector) current(ctx context.Context) (*Resources, error) {
+	ch, latest := c.subscribe()
+	defer c.unsubscribe(ch)
+
+	if latest != nil {
+		return latest, nil
+	}
+
+	select {
+	case rs := <-ch:
+		return rs, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// runCollector gathers a snapshot every interval and publishes it until the
+// server shuts down. Per-second rates are worked out here, between
+// consecutive snapshots, so every client sees the same values.
+func (app *application) runCollector() {
+	// Cancelling on shutdown cuts a collection in progress short
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-app.shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// Counters from the previous snapshot, used to turn cumulative totals
+	// into per-second rates
+	var rates rateTracker
+
+	var static staticInfo
+	haveStatic := false
+
+	ticker := time.NewTicker(app.config.interval)
+	defer ticker.Stop()
+
+	for {
+		// Hostname, boot time and platform details do not change while the
+		// host is up, so they are read once. A failure is retried on the
+		// next tick rather than ending collection for good.
+		if !haveStatic {
+			var err error
+			static, err = collectStaticInfo(app.config.displayName)
+			if err != nil {
+				app.logger.Error("reading host info", "error", err)
+			}
+			haveStatic = err == nil
+		}
+
+		if haveStatic {
+			rs := app.collectResources(ctx, static)
+			now := time.Now()
+			rates.update(&rs, now)
+			// Rounded once the rates are in, since process CPU usage is one
+			roundPercentages(&rs, app.config.round)
+			app.history.add(&rs, now)
+			app.diagnostics.record(&rs)
+			app.collector.publish(&rs)
+		}
+
+		select {
+		case <-app.shutdown:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// applyConfigFile sets flags from a TOML file whose keys are flag names,
+// e.g. `port = 9090` or `allowed-origins = ["https://a.example"]`. Flags
+// given on the command line are left alone, so the order of precedence is
+// built-in defaults, then RES_MON_PORT, then the file, then the command line.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	var values map[string]any
+	_, err := toml.DecodeFile(path, &values)
+	if err != nil {
+		return err
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for name, value := range values {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		if explicit[name] {
+			continue
+		}
+
+		err := fs.Set(name, configValueString(value))
+		if err != nil {
+			return fmt.Errorf("%s: invalid value for %q: %w", path, name, err)
+		}
+	}
+
+	return nil
+}
+
+// configValueString renders a TOML value the way it would be written on the
+// command line. Arrays become comma-separated lists.
+func configValueString(value any) string {
+	if list, ok := value.([]any); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = configValueString(item)
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(value)
+}
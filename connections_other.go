@@ -0,0 +1,25 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+
+	psnet "github.com/shirou/gopsutil/v4/net"
+)
+
+// collectConnectionStates counts the host's TCP connections in each state,
+// e.g. ESTABLISHED or TIME_WAIT. Sockets owned by other users' processes
+// may need root to be seen, so counts can be low when running unprivileged.
+func collectConnectionStates(ctx context.Context) (map[string]int, error) {
+	connections, err := psnet.ConnectionsWithoutUidsWithContext(ctx, "tcp")
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]int)
+	for _, c := range connections {
+		states[c.Status]++
+	}
+	return states, nil
+}
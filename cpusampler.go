@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"time"
+
+	"github.com/shirou/gopsutil/v4/cpu"
+)
+
+// cpuSampler turns the cumulative per-core CPU times into usage percentages
+// by comparing each reading with the one before. Only the collector
+// goroutine uses it, so it needs no locking.
+type cpuSampler struct {
+	prev []cpu.TimesStat
+}
+
+// sample returns CPU usage since the previous call. The first call has
+// nothing to compare with: it waits up to window for a short sample, or when
+// window is zero or ctx ends first, returns zeros marked WarmingUp.
+//
+// The total and the Times breakdown are worked out from the same per-core
+// times as PerCore, so they always agree with the cores, which separate
+// cpu.Percent or cpu.Times(false) calls do not guarantee.
+func (s *cpuSampler) sample(ctx context.Context, window time.Duration) (CPU, error) {
+	cur, err := cpu.TimesWithContext(ctx, true)
+	if err != nil {
+		return CPU{}, err
+	}
+
+	// A core count that changed (CPU hotplug) invalidates the baseline
+	if len(s.prev) != len(cur) {
+		s.prev = cur
+
+		warming := CPU{PerCore: make([]float64, len(cur)), WarmingUp: true}
+		if window <= 0 {
+			return warming, nil
+		}
+
+		timer := time.NewTimer(window)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return warming, nil
+		}
+
+		cur, err = cpu.TimesWithContext(ctx, true)
+		if err != nil {
+			return CPU{}, err
+		}
+	}
+
+	usage := CPU{PerCore: make([]float64, len(cur))}
+	var busySum, totalSum float64
+	var times cpu.TimesStat
+	for i := range cur {
+		busy, total := cpuBusyDelta(s.prev[i], cur[i])
+		if total > 0 {
+			usage.PerCore[i] = 100 * busy / total
+			times.User += cur[i].User - s.prev[i].User
+			times.System += cur[i].System - s.prev[i].System
+			times.Idle += cur[i].Idle - s.prev[i].Idle
+			times.Iowait += cur[i].Iowait - s.prev[i].Iowait
+			times.Steal += cur[i].Steal - s.prev[i].Steal
+			times.Nice += cur[i].Nice - s.prev[i].Nice
+		}
+		busySum += busy
+		totalSum += total
+	}
+	if totalSum > 0 {
+		usage.UsedPercent = 100 * busySum / totalSum
+		usage.Times = CPUTimes{
+			User:   100 * times.User / totalSum,
+			System: 100 * times.System / totalSum,
+			Idle:   100 * times.Idle / totalSum,
+			Iowait: 100 * times.Iowait / totalSum,
+			Steal:  100 * times.Steal / totalSum,
+			Nice:   100 * times.Nice / totalSum,
+		}
+	}
+
+	s.prev = cur
+	return usage, nil
+}
+
+// cpuBusyDelta returns how much busy and total CPU time a core accumulated
+// between two readings, counting time the same way cpu.Percent does
+func cpuBusyDelta(prev, cur cpu.TimesStat) (busy, total float64) {
+	prevBusy, prevTotal := cpuBusyTotal(prev)
+	curBusy, curTotal := cpuBusyTotal(cur)
+
+	// Counters that went backwards mean the core was reset; skip it
+	if curTotal <= prevTotal || curBusy < prevBusy {
+		return 0, 0
+	}
+
+	busy = curBusy - prevBusy
+	total = curTotal - prevTotal
+	return min(busy, total), total
+}
+
+func cpuBusyTotal(t cpu.TimesStat) (busy, total float64) {
+	total = t.Total()
+
+	// Linux already counts guest time in user time
+	if runtime.GOOS == "linux" {
+		total -= t.Guest + t.GuestNice
+	}
+
+	return total - t.Idle - t.Iowait, total
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// diagnostics counts the failures in each part of collection since the
+// server started, so a section that is blank because of a persistent error
+// (permissions, a hung mount) can be told apart from one that is empty.
+// Every collection updates it and handlers read it, so access goes through
+// the mutex.
+type diagnostics struct {
+	mu          sync.Mutex
+	collections uint64
+	partial     uint64
+	subsystems  map[string]SubsystemDiagnostics
+}
+
+// SubsystemDiagnostics describes the failures of one key of
+// Resources.Errors
+type SubsystemDiagnostics struct {
+	Failures      uint64    `json:"failures"`
+	LastError     string    `json:"lastError"`
+	LastErrorTime time.Time `json:"lastErrorTime"`
+}
+
+type Diagnostics struct {
+	// Snapshots collected, and how many of them ran out of time
+	Collections        uint64 `json:"collections"`
+	PartialCollections uint64 `json:"partialCollections"`
+
+	// Keyed like Resources.Errors; parts that never failed are absent
+	Subsystems map[string]SubsystemDiagnostics `json:"subsystems"`
+}
+
+func newDiagnostics() *diagnostics {
+	return &diagnostics{subsystems: make(map[string]SubsystemDiagnostics)}
+}
+
+// record counts a finished collection and the errors it ran into
+func (d *diagnostics) record(rs *Resources) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.collections++
+	if rs.Partial {
+		d.partial++
+	}
+
+	for key, msg := range rs.Errors {
+		s := d.subsystems[key]
+		s.Failures++
+		s.LastError = msg
+		s.LastErrorTime = rs.Timestamp
+		d.subsystems[key] = s
+	}
+}
+
+func (d *diagnostics) snapshot() Diagnostics {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return Diagnostics{
+		Collections:        d.collections,
+		PartialCollections: d.partial,
+		Subsystems:         maps.Clone(d.subsystems),
+	}
+}
+
+func (app *application) diagnosticsHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, app.diagnostics.snapshot())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
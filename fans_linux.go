@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// collectFans reads fan speeds from the hwmon drivers under
+// /sys/class/hwmon, or $HOST_SYS/class/hwmon when /sys of the host is
+// mounted elsewhere, as for gopsutil. Machines without fan sensors, which
+// includes most virtual machines, get an empty list and no error.
+func collectFans() ([]FanStat, error) {
+	sys := os.Getenv("HOST_SYS")
+	if sys == "" {
+		sys = "/sys"
+	}
+
+	inputs, err := filepath.Glob(filepath.Join(sys, "class", "hwmon", "hwmon*", "fan*_input"))
+	if err != nil {
+		return []FanStat{}, err
+	}
+	sort.Strings(inputs)
+
+	fans := make([]FanStat, 0, len(inputs))
+	for _, input := range inputs {
+		rpm, err := readSysInt(input)
+		if err != nil {
+			// A fan that is unplugged or powered down can fail to read
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return fans, err
+		}
+
+		dir := filepath.Dir(input)
+		fan := strings.TrimSuffix(filepath.Base(input), "_input")
+
+		// Named like gopsutil's temperature sensors: the chip, then the
+		// label if the driver provides one, e.g. thinkpad_fan1 or
+		// nct6775_cpu_fan
+		key := readSysString(filepath.Join(dir, "name"))
+		if label := readSysString(filepath.Join(dir, fan+"_label")); label != "" {
+			key += "_" + strings.ReplaceAll(strings.ToLower(label), " ", "_")
+		} else {
+			key += "_" + fan
+		}
+
+		fans = append(fans, FanStat{SensorKey: key, RPM: rpm})
+	}
+	return fans, nil
+}
+
+func readSysString(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func readSysInt(path string) (int64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
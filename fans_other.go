@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// collectFans returns an empty list: fan speeds are only read on Linux
+func collectFans() ([]FanStat, error) {
+	return []FanStat{}, nil
+}
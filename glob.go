@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// globRegexp compiles a shell-style glob into an anchored regular
+// expression. Unlike path.Match, * and ? match any character including /,
+// since process names such as kworker/0:1-events are not paths. [abc],
+// [a-z] and [!abc] match one character from a class, and a backslash makes
+// the next character literal.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	return compileGlob(pattern, false)
+}
+
+// pathGlobRegexp is globRegexp for paths: * and ? stay within one path
+// segment, and ** matches any number of segments, so /var/lib/docker/**
+// matches /var/lib/docker/overlay2/abc/merged.
+func pathGlobRegexp(pattern string) (*regexp.Regexp, error) {
+	return compileGlob(pattern, true)
+}
+
+func compileGlob(pattern string, isPath bool) (*regexp.Regexp, error) {
+	var re strings.Builder
+	re.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			switch {
+			case !isPath:
+				re.WriteString(".*")
+			case i+1 < len(pattern) && pattern[i+1] == '*':
+				re.WriteString(".*")
+				i++
+			default:
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			if isPath {
+				re.WriteString("[^/]")
+			} else {
+				re.WriteString(".")
+			}
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			// A ] straight after [ or [! belongs to the class
+			if end == 0 || (end == 1 && pattern[i+1] == '!') {
+				next := strings.IndexByte(pattern[i+end+2:], ']')
+				if next < 0 {
+					end = -1
+				} else {
+					end += next + 1
+				}
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("invalid pattern %q: missing ]", pattern)
+			}
+
+			class := pattern[i+1 : i+1+end]
+			re.WriteString("[")
+			if strings.HasPrefix(class, "!") {
+				re.WriteString("^")
+				class = class[1:]
+			}
+			for _, r := range class {
+				if r == '-' {
+					re.WriteRune(r)
+				} else {
+					re.WriteString(regexp.QuoteMeta(string(r)))
+				}
+			}
+			re.WriteString("]")
+			i += end + 1
+		case '\\':
+			if i+1 == len(pattern) {
+				return nil, fmt.Errorf("invalid pattern %q: trailing backslash", pattern)
+			}
+			i++
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
+
+// parseGlobs compiles every pattern with compile, globRegexp or
+// pathGlobRegexp
+func parseGlobs(patterns []string, compile func(string) (*regexp.Regexp, error)) ([]*regexp.Regexp, error) {
+	globs := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		globs = append(globs, re)
+	}
+	return globs, nil
+}
+
+// matchAny reports whether s matches any of the compiled globs
+func matchAny(globs []*regexp.Regexp, s string) bool {
+	for _, re := range globs {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
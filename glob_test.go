@@ -0,0 +1,87 @@
+package main
+
+import "testing"
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"kworker*", "kworker/0:1-events", true},
+		{"kworker*", "ksoftirqd/0", false},
+		{"*d", "systemd", true},
+		{"*d", "systemd-journal", false},
+		{"nginx?", "nginx1", true},
+		{"nginx?", "nginx", false},
+		{"?/0", "a/0", true},
+		{"[ab]ash", "bash", true},
+		{"[ab]ash", "dash", false},
+		{"[!ab]ash", "dash", true},
+		{"[!ab]ash", "bash", false},
+		{"cpu[0-3]", "cpu2", true},
+		{"cpu[0-3]", "cpu7", false},
+		{"[]]x", "]x", true},
+		{"a.b", "a.b", true},
+		{"a.b", "axb", false},
+		{"(x)+", "(x)+", true},
+		{"x+", "xx", false},
+		{"^$|", "^$|", true},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{"node", "node-exporter", false},
+	}
+
+	for _, tt := range tests {
+		re, err := globRegexp(tt.pattern)
+		if err != nil {
+			t.Fatalf("globRegexp(%q): %v", tt.pattern, err)
+		}
+		if got := re.MatchString(tt.s); got != tt.want {
+			t.Errorf("globRegexp(%q) matching %q = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestPathGlobRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"/snap/*", "/snap/core", true},
+		{"/snap/*", "/snap/core/123", false},
+		{"/snap/**", "/snap/core/123", true},
+		{"/snap/**", "/snap/core", true},
+		{"/snap/**", "/snapshots/a", false},
+		{"/var/lib/docker/**", "/var/lib/docker/overlay2/abc/merged", true},
+		{"/var/lib/docker/**", "/var/lib/containerd", false},
+		{"/**/merged", "/var/lib/docker/overlay2/abc/merged", true},
+		{"/mnt/disk?", "/mnt/disk1", true},
+		{"/mnt/disk?", "/mnt/disk/", false},
+		{"/mnt/[ab]", "/mnt/a", true},
+		{"/run/user/*/gvfs", "/run/user/1000/gvfs", true},
+		{"/run/user/*/gvfs", "/run/user/1000/x/gvfs", false},
+		{"/boot/efi", "/boot/efi", true},
+		{"/boot/efi", "/boot/efi/x", false},
+	}
+
+	for _, tt := range tests {
+		re, err := pathGlobRegexp(tt.pattern)
+		if err != nil {
+			t.Fatalf("pathGlobRegexp(%q): %v", tt.pattern, err)
+		}
+		if got := re.MatchString(tt.s); got != tt.want {
+			t.Errorf("pathGlobRegexp(%q) matching %q = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestGlobRegexpInvalid(t *testing.T) {
+	for _, pattern := range []string{"[abc", "[!", "abc\\"} {
+		_, err := globRegexp(pattern)
+		if err == nil {
+			t.Errorf("globRegexp(%q) succeeded, want an error", pattern)
+		}
+	}
+}
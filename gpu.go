@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// nvidiaSMI is the path of the nvidia-smi tool, or empty on hosts without
+// the NVIDIA driver. It is looked up once: the driver does not appear or
+// disappear while the server runs.
+var nvidiaSMI = sync.OnceValue(func() string {
+	path, err := exec.LookPath("nvidia-smi")
+	if err != nil {
+		return ""
+	}
+	return path
+})
+
+// collectGPUs reads NVIDIA GPU usage through nvidia-smi, which queries NVML
+// on our behalf. Going through the tool rather than linking NVML keeps the
+// binary free of cgo. Hosts without the tool get an empty list and no error.
+func collectGPUs(ctx context.Context) ([]GPUStat, error) {
+	path := nvidiaSMI()
+	if path == "" {
+		return []GPUStat{}, nil
+	}
+
+	out, err := exec.CommandContext(ctx, path,
+		"--query-gpu=index,name,utilization.gpu,memory.used,memory.total,temperature.gpu",
+		"--format=csv,noheader,nounits",
+	).Output()
+	if err != nil {
+		return []GPUStat{}, err
+	}
+
+	r := csv.NewReader(strings.NewReader(string(out)))
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return []GPUStat{}, err
+	}
+
+	gpus := make([]GPUStat, 0, len(records))
+	for _, rec := range records {
+		if len(rec) != 6 {
+			continue
+		}
+
+		// Fields a GPU does not support read "[N/A]" and are left at zero
+		index, _ := strconv.Atoi(rec[0])
+		utilization, _ := strconv.ParseFloat(rec[2], 64)
+		memUsed, _ := strconv.ParseUint(rec[3], 10, 64)
+		memTotal, _ := strconv.ParseUint(rec[4], 10, 64)
+		temperature, _ := strconv.ParseFloat(rec[5], 64)
+
+		gpus = append(gpus, GPUStat{
+			Index:              index,
+			Name:               rec[1],
+			UtilizationPercent: utilization,
+			MemoryUsed:         memUsed * 1024 * 1024,
+			MemoryTotal:        memTotal * 1024 * 1024,
+			Temperature:        temperature,
+		})
+	}
+	return gpus, nil
+}
+
+// gpuCache holds the result of the latest nvidia-smi run. Starting the tool
+// takes a good fraction of a second, so runGPUs polls it in the background
+// every -gpu-interval and snapshots take the cached list instead of waiting
+// on it within their deadline.
+type gpuCache struct {
+	mu   sync.Mutex
+	gpus []GPUStat
+	err  error
+}
+
+func newGPUCache() *gpuCache {
+	return &gpuCache{gpus: []GPUStat{}}
+}
+
+func (c *gpuCache) store(gpus []GPUStat, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gpus, c.err = gpus, err
+}
+
+// load returns a copy of the latest list, which the caller may round in
+// place, and the error of the run that produced it
+func (c *gpuCache) load() ([]GPUStat, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.gpus), c.err
+}
+
+// runGPUs polls nvidia-smi every -gpu-interval until shutdown. It returns
+// straight away on hosts without the tool, leaving the cached list empty.
+func (app *application) runGPUs() {
+	if nvidiaSMI() == "" {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-app.shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(app.config.gpuInterval)
+	defer ticker.Stop()
+
+	for {
+		// A hung driver must not hold up the next poll
+		pollCtx, pollCancel := context.WithTimeout(ctx, app.config.gpuInterval)
+		gpus, err := collectGPUs(pollCtx)
+		pollCancel()
+		if ctx.Err() == nil {
+			app.gpus.store(gpus, err)
+		}
+
+		select {
+		case <-app.shutdown:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type GPUStat struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+
+	// Percentage of time the GPU was busy over the driver's last sample period
+	UtilizationPercent float64 `json:"utilizationPercent"`
+
+	// Video memory in bytes
+	MemoryUsed  uint64 `json:"memoryUsed"`
+	MemoryTotal uint64 `json:"memoryTotal"`
+
+	// Core temperature in degrees Celsius
+	Temperature float64 `json:"temperature"`
+}
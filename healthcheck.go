@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/shirou/gopsutil/v4/mem"
+)
+
+// healthzHandler reports that the server is up. It collects nothing, so it
+// is cheap enough for a liveness probe.
+func (app *application) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// readyzHandler reads memory usage as a quick check that gopsutil can query
+// the host before traffic is sent here
+func (app *application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := mem.VirtualMemory()
+	if err != nil {
+		app.logger.Warn("readiness check failed", "error", err)
+
+		data := map[string]string{"status": "unavailable", "error": err.Error()}
+		err = app.writeJSON(w, http.StatusServiceUnavailable, data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyMetrics maps each metric that /api/history can return to the value
+// it reads from a snapshot
+var historyMetrics = map[string]func(rs *Resources) float64{
+	"load1":              func(rs *Resources) float64 { return rs.LoadAverage.Load1 },
+	"load5":              func(rs *Resources) float64 { return rs.LoadAverage.Load5 },
+	"load15":             func(rs *Resources) float64 { return rs.LoadAverage.Load15 },
+	"cpu.usedPercent":    func(rs *Resources) float64 { return rs.CPU.UsedPercent },
+	"memory.used":        func(rs *Resources) float64 { return float64(rs.Memory.Used) },
+	"memory.usedPercent": func(rs *Resources) float64 { return rs.Memory.UsedPercent },
+	"swap.usedPercent":   func(rs *Resources) float64 { return rs.Swap.UsedPercent },
+}
+
+type historySample struct {
+	at     time.Time
+	values map[string]float64
+}
+
+type HistoryPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// history is a fixed-size ring buffer of recent samples. It is shared by
+// every connection, so all access goes through the mutex.
+type history struct {
+	mu        sync.Mutex
+	samples   []historySample
+	next      int
+	full      bool
+	retention time.Duration
+}
+
+// newHistory returns a buffer that keeps the last size samples. A size of
+// zero keeps nothing. Samples older than retention are no longer returned;
+// a retention of zero keeps them until the buffer wraps around.
+func newHistory(size int, retention time.Duration) *history {
+	return &history{samples: make([]historySample, size), retention: retention}
+}
+
+// add records the history metrics of a snapshot, overwriting the oldest
+// sample once the buffer is full
+func (h *history) add(rs *Resources, at time.Time) {
+	if len(h.samples) == 0 {
+		return
+	}
+
+	values := make(map[string]float64, len(historyMetrics))
+	for name, value := range historyMetrics {
+		values[name] = value(rs)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.samples[h.next] = historySample{at: at, values: values}
+	h.next = (h.next + 1) % len(h.samples)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// series returns the values of one metric recorded after since, oldest
+// first
+func (h *history) series(metric string, since time.Time) []HistoryPoint {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.retention > 0 {
+		if cutoff := time.Now().Add(-h.retention); cutoff.After(since) {
+			since = cutoff
+		}
+	}
+
+	ordered := h.samples[:h.next]
+	if h.full {
+		ordered = append(slices.Clone(h.samples[h.next:]), h.samples[:h.next]...)
+	}
+
+	points := make([]HistoryPoint, 0, len(ordered))
+	for _, s := range ordered {
+		if s.at.Before(since) {
+			continue
+		}
+		points = append(points, HistoryPoint{Timestamp: s.at, Value: s.values[metric]})
+	}
+	return points
+}
+
+func (app *application) historyHandler(w http.ResponseWriter, r *http.Request) {
+	metric, since, err := parseHistoryQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data := map[string]any{
+		"metric": metric,
+		"points": app.history.series(metric, since),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// historyCSVHandler streams the same series as historyHandler as CSV, for
+// loading into a spreadsheet
+func (app *application) historyCSVHandler(w http.ResponseWriter, r *http.Request) {
+	metric, since, err := parseHistoryQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, metric))
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"timestamp", metric})
+	for _, p := range app.history.series(metric, since) {
+		_ = cw.Write([]string{
+			p.Timestamp.UTC().Format(time.RFC3339),
+			strconv.FormatFloat(p.Value, 'f', -1, 64),
+		})
+	}
+	cw.Flush()
+}
+
+// parseHistoryQuery reads ?metric= and the optional ?since=, a duration such
+// as 15m that limits the series to that much recent history
+func parseHistoryQuery(v url.Values) (string, time.Time, error) {
+	metric := v.Get("metric")
+	if _, ok := historyMetrics[metric]; !ok {
+		names := make([]string, 0, len(historyMetrics))
+		for name := range historyMetrics {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		return "", time.Time{}, fmt.Errorf("invalid metric %q: must be one of %s", metric, strings.Join(names, ", "))
+	}
+
+	var since time.Time
+	if s := v.Get("since"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return "", time.Time{}, fmt.Errorf("invalid since %q: must be a positive duration such as 1h", s)
+		}
+		since = time.Now().Add(-d)
+	}
+
+	return metric, since, nil
+}
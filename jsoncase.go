@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// marshalJSON encodes v with the field names chosen by -json-case
+func (app *application) marshalJSON(v any) ([]byte, error) {
+	return marshalJSONCase(v, app.config.jsonCase)
+}
+
+// marshalJSONCase encodes v with snake_case field names when jsonCase is
+// snake. The struct tags are camelCase, so otherwise it is just
+// json.Marshal.
+func marshalJSONCase(v any, jsonCase string) ([]byte, error) {
+	if jsonCase != "snake" {
+		return json.Marshal(v)
+	}
+
+	var buf bytes.Buffer
+	err := encodeSnake(&buf, reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var (
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// encodeSnake writes v as JSON the way json.Marshal would, except that the
+// name of every struct field is converted with snakeCase. Map keys are data,
+// such as TCP states or mountpoints, and are left alone.
+func encodeSnake(buf *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		buf.WriteString("null")
+		return nil
+	}
+
+	// Types that encode themselves, such as time.Time
+	if v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType) {
+		return encodeValue(buf, v)
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		return encodeSnake(buf, v.Elem())
+
+	case reflect.Struct:
+		buf.WriteByte('{')
+		first := true
+		err := encodeSnakeFields(buf, v, &first)
+		if err != nil {
+			return err
+		}
+		buf.WriteByte('}')
+		return nil
+
+	case reflect.Map:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+
+		// Sorted like json.Marshal sorts them
+		keys := v.MapKeys()
+		slices.SortFunc(keys, func(a, b reflect.Value) int {
+			return strings.Compare(a.String(), b.String())
+		})
+
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			err := encodeValue(buf, key)
+			if err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			err = encodeSnake(buf, v.MapIndex(key))
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+
+		buf.WriteByte('[')
+		for i := range v.Len() {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			err := encodeSnake(buf, v.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	}
+
+	return encodeValue(buf, v)
+}
+
+// encodeSnakeFields writes the fields of a struct, flattening embedded
+// structs into it as json.Marshal does
+func encodeSnakeFields(buf *bytes.Buffer, v reflect.Value, first *bool) error {
+	t := v.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		value := v.Field(i)
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			err := encodeSnakeFields(buf, value, first)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if hasOption(opts, "omitzero") && value.IsZero() {
+			continue
+		}
+		if hasOption(opts, "omitempty") && isEmptyValue(value) {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		if !*first {
+			buf.WriteByte(',')
+		}
+		*first = false
+
+		err := encodeValue(buf, reflect.ValueOf(snakeCase(name)))
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(':')
+		err = encodeSnake(buf, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeValue(buf *bytes.Buffer, v reflect.Value) error {
+	js, err := json.Marshal(v.Interface())
+	if err != nil {
+		return err
+	}
+	buf.Write(js)
+	return nil
+}
+
+func hasOption(opts, name string) bool {
+	for opt := range strings.SplitSeq(opts, ",") {
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmptyValue reports whether omitempty leaves v out, by the same rules as
+// encoding/json
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
+
+// snakeCase converts a camelCase field name to snake_case. A run of capitals
+// is kept together as one word, including a plural s after it, so memoryMB
+// becomes memory_mb and numFDs becomes num_fds.
+func snakeCase(name string) string {
+	runes := []rune(name)
+
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			switch {
+			case unicode.IsLower(prev) || unicode.IsDigit(prev):
+				b.WriteByte('_')
+			case unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1]):
+				// The last capital of a run starts the next word, as in
+				// IOCounters, unless all that follows is a plural s
+				plural := runes[i+1] == 's' && (i+2 == len(runes) || unicode.IsUpper(runes[i+2]))
+				if !plural {
+					b.WriteByte('_')
+				}
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSnakeCase(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"usedPercent", "used_percent"},
+		{"CPUPercent", "cpu_percent"},
+		{"DiskIO", "disk_io"},
+		{"diskIO", "disk_io"},
+		{"IOCounters", "io_counters"},
+		{"memoryMB", "memory_mb"},
+		{"numFDs", "num_fds"},
+		{"load1", "load1"},
+		{"ioTimePerSec", "io_time_per_sec"},
+		{"pid", "pid"},
+	}
+
+	for _, tt := range tests {
+		if got := snakeCase(tt.in); got != tt.want {
+			t.Errorf("snakeCase(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMarshalJSONCaseSnake(t *testing.T) {
+	type Embedded struct {
+		InnerValue int `json:"innerValue"`
+	}
+	type outer struct {
+		Embedded
+		CPUPercent float64           `json:"cpuPercent"`
+		DiskIO     []int             `json:"diskIO"`
+		States     map[string]int    `json:"connectionStates"`
+		Skipped    string            `json:"-"`
+		Optional   string            `json:"optionalField,omitempty"`
+		When       time.Time         `json:"lastSeen,omitzero"`
+		NilMap     map[string]string `json:"nilMap"`
+		Untagged   bool
+		private    int
+	}
+
+	tests := []struct {
+		name string
+		v    any
+		want string
+	}{
+		{
+			name: "zero values",
+			v:    outer{},
+			want: `{"inner_value":0,"cpu_percent":0,"disk_io":null,"connection_states":null,"nil_map":null,"untagged":false}`,
+		},
+		{
+			name: "set values",
+			v: outer{
+				Embedded:   Embedded{InnerValue: 7},
+				CPUPercent: 1.5,
+				DiskIO:     []int{},
+				States:     map[string]int{"TIME_WAIT": 2, "LISTEN": 1},
+				Skipped:    "secret",
+				Optional:   "x",
+				When:       time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+				Untagged:   true,
+				private:    1,
+			},
+			want: `{"inner_value":7,"cpu_percent":1.5,"disk_io":[],"connection_states":{"LISTEN":1,"TIME_WAIT":2},"optional_field":"x","last_seen":"2026-01-02T03:04:05Z","nil_map":null,"untagged":true}`,
+		},
+		{
+			name: "nil pointer",
+			v:    (*outer)(nil),
+			want: `null`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := marshalJSONCase(tt.v, "snake")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+			if !json.Valid(got) {
+				t.Errorf("invalid JSON: %s", got)
+			}
+		})
+	}
+}
+
+// TestMarshalJSONCaseCamel checks that camel output is json.Marshal's
+func TestMarshalJSONCaseCamel(t *testing.T) {
+	rs := &Resources{Hostname: "h", Processes: []ProcessInfo{{PID: 1, Name: "init"}}}
+
+	got, err := marshalJSONCase(rs, "camel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := json.Marshal(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
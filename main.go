@@ -1,27 +1,45 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"embed"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"io/fs"
-	"log"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
 	"os"
 	"os/signal"
+	"path"
+	"regexp"
+	"runtime"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/shirou/gopsutil/v4/cpu"
 	"github.com/shirou/gopsutil/v4/disk"
 	"github.com/shirou/gopsutil/v4/host"
 	"github.com/shirou/gopsutil/v4/load"
 	"github.com/shirou/gopsutil/v4/mem"
+	psnet "github.com/shirou/gopsutil/v4/net"
 	"github.com/shirou/gopsutil/v4/process"
+	"github.com/shirou/gopsutil/v4/sensors"
 )
 
 // Embed the entire "static" directory, which includes assets
@@ -29,195 +47,1738 @@ import (
 //go:embed "static"
 var embeddedFiles embed.FS
 
+const (
+	// Time allowed for the client to answer a ping before it is considered dead
+	pongWait = 60 * time.Second
+
+	// How often pings are sent; must be less than pongWait
+	pingPeriod = (pongWait * 9) / 10
+
+	// Time allowed to write a control message to the client
+	writeWait = 10 * time.Second
+
+	// How long clients are asked to wait before reconnecting after the
+	// server shuts down or turns them away for being full, before jitter
+	shutdownRetryAfter = 5 * time.Second
+	busyRetryAfter     = 10 * time.Second
+)
+
+type config struct {
+	host        string
+	port        int
+	topN        int
+	interval    time.Duration
+	netLoopback bool
+
+	// Unix domain socket to listen on instead of host and port, and
+	// whether to replace a file already at that path
+	unixSocket      string
+	unixSocketForce bool
+
+	// Origins allowed to open a WebSocket; empty means same host only
+	allowedOrigins []string
+
+	// Development mode: any origin may open a WebSocket
+	dev bool
+
+	// Basic Auth credentials; auth is enforced only when both are set
+	authUser string
+	authPass string
+
+	// TLS certificate and key files; HTTPS is served only when both are set
+	tlsCert string
+	tlsKey  string
+
+	// Domains to get Let's Encrypt certificates for, comma-separated, and
+	// the directory they are cached in
+	autocertDomain string
+	autocertCache  string
+
+	// Offer permessage-deflate compression of WebSocket frames
+	compress bool
+
+	// Minimum level of log messages to write
+	logLevel slog.Level
+
+	// Naming of JSON fields: camel or snake
+	jsonCase string
+
+	// Unit of memory, swap and disk sizes sent to clients: bytes, MB or GB
+	memUnit string
+
+	// Number of recent snapshots kept for /api/history, and how long they
+	// are kept for
+	historySize      int
+	historyRetention time.Duration
+
+	// Glob patterns of filesystem types and mountpoints to leave out, and
+	// whether to list pseudo filesystems as well
+	diskExcludeFstype []*regexp.Regexp
+	diskExcludeMount  []*regexp.Regexp
+	diskIncludeAll    bool
+
+	// When set, the only mountpoints whose usage is read
+	diskMounts []string
+
+	// Time allowed to write one snapshot to a WebSocket client
+	wsWriteTimeout time.Duration
+
+	// Size above which WebSocket snapshots lose processes, or 0 for no limit
+	maxPayloadBytes int
+
+	// Most WebSocket clients served at once, or 0 for no limit
+	maxConnections int
+
+	// Thresholds that trigger a webhook alert, 0 to disable each, and how
+	// often a sustained breach is repeated
+	alertMemPct   float64
+	alertLoad1    float64
+	alertWebhook  string
+	alertCooldown time.Duration
+
+	// Prefix every route is served under, e.g. /resmon, or empty for the root
+	basePath string
+
+	// Lowercased glob patterns of process names left out of every snapshot,
+	// see globRegexp
+	processHide []*regexp.Regexp
+
+	// Leave this server's own process out of the list
+	hideSelf bool
+
+	// Serve Go runtime profiles under /debug/pprof/
+	pprof bool
+
+	// Serve only the API and WebSocket, without the dashboard
+	noUI bool
+
+	// How long the first snapshot waits to measure CPU usage
+	cpuSampleWindow time.Duration
+
+	// Longest command line sent in snapshots, in characters, or 0 for no limit
+	cmdlineMax int
+
+	// Patterns whose matches are masked in command lines and environment
+	// variables, see parseRedact
+	redact []*regexp.Regexp
+
+	// Decimal places percentages are rounded to, or -1 for full precision
+	round int
+
+	// File to append every snapshot to as JSON lines, and the size in MB
+	// at which it is rotated; 0 never rotates
+	metricsFile      string
+	metricsFileMaxMB int
+
+	// Linux hosts to poll over SSH, as user@host, and how often
+	remotes        []string
+	remoteInterval time.Duration
+
+	// Name reported as the hostname instead of os.Hostname()
+	displayName string
+
+	// UDP address of a StatsD server that snapshots are pushed to
+	statsdAddr string
+
+	// Skip reading the process table; snapshots carry an empty list
+	noProcesses bool
+
+	// How often the process table is read, or 0 for every snapshot
+	processInterval time.Duration
+
+	// Most processes read per collection, or 0 for no limit
+	maxProcessScan int
+
+	// How often nvidia-smi is run for GPU stats
+	gpuInterval time.Duration
+}
+
 type application struct {
-	port int
-	wg   sync.WaitGroup
+	config config
+	logger *slog.Logger
+	wg     sync.WaitGroup
+
+	// Number of open WebSocket connections
+	connections atomic.Int64
+
+	// Latest snapshot, shared by every connection
+	collector *collector
+
+	// The dashboard page, parsed by routes()
+	indexTemplate *template.Template
+
+	// Recent snapshots shared by every connection
+	history *history
+
+	// Collection failures since startup, for /api/diagnostics
+	diagnostics *diagnostics
+
+	// GPU stats from the latest nvidia-smi run
+	gpus *gpuCache
+
+	// Hosts polled over SSH with -remote
+	remotes []*remoteHost
+
+	// Closed when the server starts shutting down, so long-lived WebSocket
+	// handlers can finish instead of waiting for their clients to leave
+	shutdown chan struct{}
+}
+
+func main() {
+	var cfg config
+
+	// The RES_MON_PORT environment variable only changes the default, so an
+	// explicit -port flag always wins.
+	defaultPort := 8080
+	if v := os.Getenv("RES_MON_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid RES_MON_PORT %q: must be a number\n", v)
+			os.Exit(2)
+		}
+		defaultPort = port
+	}
+
+	flag.StringVar(&cfg.host, "host", "", "Address to bind to (default all interfaces)")
+	flag.StringVar(&cfg.displayName, "display-name", "", "Name to report for this host instead of its hostname")
+	flag.StringVar(&cfg.unixSocket, "unix-socket", "", "Listen on this Unix domain socket instead of -host and -port")
+	flag.BoolVar(&cfg.unixSocketForce, "unix-socket-force", false, "Replace a file already at the -unix-socket path")
+	flag.StringVar(&cfg.basePath, "base-path", "", "Path prefix to serve every route under, e.g. /resmon")
+	flag.IntVar(&cfg.port, "port", defaultPort, "HTTP server port (env RES_MON_PORT)")
+	flag.IntVar(&cfg.topN, "top", 50, "Maximum number of processes per snapshot (0 for no limit)")
+	flag.DurationVar(&cfg.interval, "interval", time.Second, "Delay between snapshots (minimum 100ms)")
+	flag.BoolVar(&cfg.netLoopback, "net-loopback", false, "Include loopback interfaces in network stats")
+	flag.BoolVar(&cfg.dev, "dev", false, "Development mode: accept WebSocket connections from any origin")
+	flag.Func("allowed-origins", "Comma-separated origins allowed to open a WebSocket, or * for any (default same host)", func(v string) error {
+		cfg.allowedOrigins = splitList(v)
+		return nil
+	})
+	flag.StringVar(&cfg.authUser, "auth-user", "", "Username for HTTP Basic Auth (requires -auth-pass)")
+	flag.StringVar(&cfg.authPass, "auth-pass", "", "Password for HTTP Basic Auth (requires -auth-user)")
+	flag.StringVar(&cfg.tlsCert, "tls-cert", "", "TLS certificate file; serves HTTPS when set with -tls-key")
+	flag.StringVar(&cfg.tlsKey, "tls-key", "", "TLS private key file; serves HTTPS when set with -tls-cert")
+	flag.StringVar(&cfg.autocertDomain, "autocert-domain", "", "Serve HTTPS with certificates from Let's Encrypt for these comma-separated domains")
+	flag.StringVar(&cfg.autocertCache, "autocert-cache", "autocert-cache", "Directory where Let's Encrypt certificates are kept")
+	flag.BoolVar(&cfg.compress, "compress", true, "Compress WebSocket frames when the client supports it")
+	flag.TextVar(&cfg.logLevel, "log-level", slog.LevelInfo, "Minimum log level (debug, info, warn or error)")
+	flag.StringVar(&cfg.jsonCase, "json-case", "camel", "Naming of JSON fields: camel (usedPercent) or snake (used_percent)")
+	flag.StringVar(&cfg.memUnit, "mem-unit", "bytes", "Unit of memory, swap and disk sizes in snapshots: bytes, MB or GB")
+	flag.IntVar(&cfg.historySize, "history-size", 300, "Number of recent snapshots kept for /api/history (0 to disable)")
+	flag.DurationVar(&cfg.historyRetention, "history-retention", 0, "How long history samples are kept, at most -history-size times -interval (0 to keep them until -history-size is reached)")
+	flag.Func("disk-exclude-fstype", "Comma-separated filesystem type globs to leave out, e.g. tmpfs,overlay", func(v string) error {
+		var err error
+		cfg.diskExcludeFstype, err = parseGlobs(splitList(v), globRegexp)
+		return err
+	})
+	flag.Func("disk-exclude-mount", "Comma-separated mountpoint globs to leave out, where ** spans directories, e.g. /snap/**,/var/lib/docker/**", func(v string) error {
+		var err error
+		cfg.diskExcludeMount, err = parseGlobs(splitList(v), pathGlobRegexp)
+		return err
+	})
+	flag.BoolVar(&cfg.diskIncludeAll, "disk-include-all", false, "List pseudo and duplicate filesystems too")
+	flag.Func("disk-mounts", "Comma-separated mountpoints to report, e.g. /,/data (default all)", func(v string) error {
+		cfg.diskMounts = splitList(v)
+		return nil
+	})
+	flag.DurationVar(&cfg.wsWriteTimeout, "ws-write-timeout", 10*time.Second, "Time allowed to write one snapshot before dropping a WebSocket client")
+	flag.IntVar(&cfg.maxPayloadBytes, "max-payload-bytes", 1<<20, "Largest WebSocket snapshot in bytes; the process list is trimmed to fit (0 for no limit)")
+	flag.IntVar(&cfg.maxConnections, "max-connections", 100, "Maximum concurrent WebSocket clients (0 for no limit)")
+	flag.Float64Var(&cfg.alertMemPct, "alert-mem-pct", 0, "Alert when memory use reaches this percentage (0 to disable)")
+	flag.Float64Var(&cfg.alertLoad1, "alert-load1", 0, "Alert when the 1-minute load average reaches this value (0 to disable)")
+	flag.StringVar(&cfg.alertWebhook, "alert-webhook", "", "URL that alerts are POSTed to as JSON")
+	flag.DurationVar(&cfg.alertCooldown, "alert-cooldown", 5*time.Minute, "Minimum time between repeated alerts for a sustained breach")
+	flag.Func("process-hide", "Comma-separated process names or globs to leave out, case-insensitive, e.g. kworker*,ksoftirqd*", func(v string) error {
+		var err error
+		cfg.processHide, err = parseGlobs(splitList(strings.ToLower(v)), globRegexp)
+		return err
+	})
+	flag.BoolVar(&cfg.hideSelf, "hide-self", false, "Leave the res_mon process itself out of the process list")
+	flag.BoolVar(&cfg.pprof, "pprof", false, "Serve Go runtime profiles under /debug/pprof/")
+	flag.BoolVar(&cfg.noUI, "no-ui", false, "Serve only the API and WebSocket, not the dashboard")
+	flag.DurationVar(&cfg.cpuSampleWindow, "cpu-sample-window", 200*time.Millisecond, "How long the first snapshot measures CPU usage for (0 to send it marked as warming up)")
+	flag.IntVar(&cfg.cmdlineMax, "cmdline-max", 256, "Longest process command line sent in snapshots, in characters (0 for no limit)")
+	cfg.redact, _ = parseRedact(splitList(defaultRedact))
+	flag.Func("redact", "Comma-separated key names or regular expressions to mask in command lines and environment variables (default \""+defaultRedact+"\")", func(v string) error {
+		var err error
+		cfg.redact, err = parseRedact(splitList(v))
+		return err
+	})
+	flag.IntVar(&cfg.round, "round", 2, "Decimal places to round percentages to (-1 for full precision)")
+	flag.StringVar(&cfg.statsdAddr, "statsd-addr", "", "StatsD server to push gauges to every interval, e.g. 127.0.0.1:8125")
+	flag.StringVar(&cfg.metricsFile, "metrics-file", "", "Append every snapshot to this file as newline-delimited JSON")
+	flag.IntVar(&cfg.metricsFileMaxMB, "metrics-file-max-mb", 100, "Rotate -metrics-file to FILE.1 once it reaches this size in MB (0 for no limit)")
+	flag.Func("remote", "Comma-separated user@host targets to poll over SSH, e.g. admin@web1,admin@web2", func(v string) error {
+		for _, target := range splitList(v) {
+			if strings.HasPrefix(target, "-") {
+				return fmt.Errorf("invalid target %q", target)
+			}
+			cfg.remotes = append(cfg.remotes, target)
+		}
+		return nil
+	})
+	flag.DurationVar(&cfg.remoteInterval, "remote-interval", 5*time.Second, "Delay between polls of each -remote host (minimum 1s)")
+	flag.BoolVar(&cfg.noProcesses, "no-processes", false, "Skip process collection to keep snapshots cheap")
+	flag.DurationVar(&cfg.gpuInterval, "gpu-interval", 5*time.Second, "Delay between nvidia-smi runs for GPU stats (minimum 100ms)")
+	flag.DurationVar(&cfg.processInterval, "process-interval", 0, "Delay between reads of the process table, at least -interval; snapshots in between repeat the last list (0 for every snapshot)")
+	flag.IntVar(&cfg.maxProcessScan, "max-process-scan", 0, "Most processes read per collection, newest PIDs first (0 for no limit)")
+	configFile := flag.String("config", "", "TOML file of settings keyed by flag name; command-line flags override it")
+	flag.Parse()
+
+	if *configFile != "" {
+		err := applyConfigFile(flag.CommandLine, *configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid config: %s\n", err)
+			os.Exit(2)
+		}
+	}
+
+	// Accept /resmon, /resmon/ and resmon alike; / means the root
+	if cfg.basePath != "" {
+		cfg.basePath = strings.TrimSuffix(path.Clean("/"+cfg.basePath), "/")
+	}
+
+	if cfg.historyRetention < 0 {
+		fmt.Fprintf(os.Stderr, "invalid history-retention %s: must be 0 or greater\n", cfg.historyRetention)
+		os.Exit(2)
+	}
+
+	if cfg.historySize < 0 {
+		fmt.Fprintf(os.Stderr, "invalid history-size %d: must be 0 or greater\n", cfg.historySize)
+		os.Exit(2)
+	}
+
+	if (cfg.tlsCert == "") != (cfg.tlsKey == "") {
+		fmt.Fprintln(os.Stderr, "-tls-cert and -tls-key must be set together")
+		os.Exit(2)
+	}
+
+	if cfg.autocertDomain != "" && cfg.tlsCert != "" {
+		fmt.Fprintln(os.Stderr, "-autocert-domain cannot be combined with -tls-cert and -tls-key")
+		os.Exit(2)
+	}
+
+	if (cfg.authUser == "") != (cfg.authPass == "") {
+		fmt.Fprintln(os.Stderr, "-auth-user and -auth-pass must be set together")
+		os.Exit(2)
+	}
+
+	if cfg.interval < 100*time.Millisecond {
+		fmt.Fprintf(os.Stderr, "invalid interval %s: must be at least 100ms\n", cfg.interval)
+		os.Exit(2)
+	}
+
+	// The ring buffer wraps after -history-size snapshots whatever the
+	// retention, so a longer retention would silently never be reached
+	if span := time.Duration(cfg.historySize) * cfg.interval; cfg.historySize > 0 && cfg.historyRetention > span {
+		fmt.Fprintf(os.Stderr, "invalid history-retention %s: -history-size %d at -interval %s only holds %s; raise -history-size to at least %d\n",
+			cfg.historyRetention, cfg.historySize, cfg.interval, span, (cfg.historyRetention+cfg.interval-1)/cfg.interval)
+		os.Exit(2)
+	}
+
+	if cfg.gpuInterval < 100*time.Millisecond {
+		fmt.Fprintf(os.Stderr, "invalid gpu-interval %s: must be at least 100ms\n", cfg.gpuInterval)
+		os.Exit(2)
+	}
+
+	if cfg.processInterval != 0 && cfg.processInterval < cfg.interval {
+		fmt.Fprintf(os.Stderr, "invalid process-interval %s: must be 0 or at least -interval\n", cfg.processInterval)
+		os.Exit(2)
+	}
+
+	if cfg.alertMemPct < 0 || cfg.alertLoad1 < 0 {
+		fmt.Fprintln(os.Stderr, "alert thresholds must be 0 or greater")
+		os.Exit(2)
+	}
+
+	if (cfg.alertMemPct > 0 || cfg.alertLoad1 > 0) && cfg.alertWebhook == "" {
+		fmt.Fprintln(os.Stderr, "-alert-webhook is required with -alert-mem-pct or -alert-load1")
+		os.Exit(2)
+	}
+
+	if cfg.alertWebhook != "" {
+		u, err := url.Parse(cfg.alertWebhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fmt.Fprintf(os.Stderr, "invalid alert-webhook %q: must be an http or https URL\n", cfg.alertWebhook)
+			os.Exit(2)
+		}
+	}
+
+	if cfg.alertCooldown <= 0 {
+		fmt.Fprintf(os.Stderr, "invalid alert-cooldown %s: must be greater than 0\n", cfg.alertCooldown)
+		os.Exit(2)
+	}
+
+	if cfg.statsdAddr != "" {
+		_, err := net.ResolveUDPAddr("udp", cfg.statsdAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid statsd-addr %q: %s\n", cfg.statsdAddr, err)
+			os.Exit(2)
+		}
+	}
+
+	if cfg.remoteInterval < time.Second {
+		fmt.Fprintf(os.Stderr, "invalid remote-interval %v: must be at least 1s\n", cfg.remoteInterval)
+		os.Exit(2)
+	}
+
+	if cfg.jsonCase != "camel" && cfg.jsonCase != "snake" {
+		fmt.Fprintf(os.Stderr, "invalid json-case %q: must be camel or snake\n", cfg.jsonCase)
+		os.Exit(2)
+	}
+
+	if _, ok := memUnits[cfg.memUnit]; !ok {
+		fmt.Fprintf(os.Stderr, "invalid mem-unit %q: must be bytes, MB or GB\n", cfg.memUnit)
+		os.Exit(2)
+	}
+
+	if cfg.round < -1 {
+		fmt.Fprintf(os.Stderr, "invalid round %d: must be -1 or greater\n", cfg.round)
+		os.Exit(2)
+	}
+
+	if cfg.metricsFileMaxMB < 0 {
+		fmt.Fprintf(os.Stderr, "invalid metrics-file-max-mb %d: must be 0 or greater\n", cfg.metricsFileMaxMB)
+		os.Exit(2)
+	}
+
+	if cfg.maxProcessScan < 0 {
+		fmt.Fprintf(os.Stderr, "invalid max-process-scan %d: must be 0 or greater\n", cfg.maxProcessScan)
+		os.Exit(2)
+	}
+
+	if cfg.cmdlineMax < 0 {
+		fmt.Fprintf(os.Stderr, "invalid cmdline-max %d: must be 0 or greater\n", cfg.cmdlineMax)
+		os.Exit(2)
+	}
+
+	if cfg.cpuSampleWindow < 0 {
+		fmt.Fprintf(os.Stderr, "invalid cpu-sample-window %s: must be 0 or greater\n", cfg.cpuSampleWindow)
+		os.Exit(2)
+	}
+
+	if cfg.wsWriteTimeout <= 0 {
+		fmt.Fprintf(os.Stderr, "invalid ws-write-timeout %s: must be greater than 0\n", cfg.wsWriteTimeout)
+		os.Exit(2)
+	}
+
+	if cfg.maxPayloadBytes < 0 {
+		fmt.Fprintf(os.Stderr, "invalid max-payload-bytes %d: must be 0 or greater\n", cfg.maxPayloadBytes)
+		os.Exit(2)
+	}
+
+	if cfg.maxConnections < 0 {
+		fmt.Fprintf(os.Stderr, "invalid max-connections %d: must be 0 or greater\n", cfg.maxConnections)
+		os.Exit(2)
+	}
+
+	if cfg.topN < 0 {
+		fmt.Fprintf(os.Stderr, "invalid top %d: must be 0 or greater\n", cfg.topN)
+		os.Exit(2)
+	}
+
+	if cfg.port < 1 || cfg.port > 65535 {
+		fmt.Fprintf(os.Stderr, "invalid port %d: must be between 1 and 65535\n", cfg.port)
+		os.Exit(2)
+	}
+
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: cfg.logLevel}))
+
+	app := &application{
+		config:      cfg,
+		logger:      logger,
+		collector:   newCollector(),
+		history:     newHistory(cfg.historySize, cfg.historyRetention),
+		diagnostics: newDiagnostics(),
+		gpus:        newGPUCache(),
+		shutdown:    make(chan struct{}),
+	}
+	for _, target := range cfg.remotes {
+		app.remotes = append(app.remotes, &remoteHost{target: target})
+	}
+
+	// Any web page the operator's browser visits could then read the
+	// dashboard's data, so make sure this is noticed
+	if cfg.dev || slices.Contains(cfg.allowedOrigins, "*") {
+		logger.Warn("websocket origin check disabled: any website can connect through a visitor's browser, do not run like this in production",
+			"dev", cfg.dev, "allowed_origins", cfg.allowedOrigins)
+	}
+
+	err := app.serve()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+func (app *application) routes() http.Handler {
+	r := http.NewServeMux()
+
+	// Without the dashboard, / and /static/ match no route and get a 404
+	if !app.config.noUI {
+		staticFS, err := fs.Sub(embeddedFiles, "static")
+		if err != nil {
+			app.logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		// Parsed once here so a broken template stops the server at startup
+		// rather than failing every page load
+		app.indexTemplate, err = template.ParseFS(embeddedFiles, "static/index.html")
+		if err != nil {
+			app.logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		r.Handle("/static/", http.StripPrefix("/static", http.FileServer(http.FS(staticFS))))
+		r.HandleFunc("/", app.serveHTMLHandler)
+	}
+	r.HandleFunc("/ws", app.wsHandler)
+	r.HandleFunc("GET /api/snapshot", app.snapshotHandler)
+	r.HandleFunc("GET /api/connections", app.connectionsHandler)
+	r.HandleFunc("GET /api/disks", app.disksHandler)
+	r.HandleFunc("GET /api/diagnostics", app.diagnosticsHandler)
+	r.HandleFunc("GET /api/hosts", app.hostsHandler)
+	r.HandleFunc("GET /api/hosts/{target}/snapshot", app.remoteSnapshotHandler)
+	r.HandleFunc("GET /api/history", app.historyHandler)
+	r.HandleFunc("GET /api/history.csv", app.historyCSVHandler)
+	r.HandleFunc("GET /api/processes", app.processesHandler)
+	r.HandleFunc("GET /api/process/{pid}", app.processHandler)
+	r.HandleFunc("GET /api/process/{pid}/children", app.childrenHandler)
+	r.HandleFunc("GET /api/process/{pid}/cmdline", app.cmdlineHandler)
+	r.HandleFunc("GET /metrics", app.metricsHandler)
+	r.HandleFunc("GET /healthz", app.healthzHandler)
+	r.HandleFunc("GET /readyz", app.readyzHandler)
+
+	// Killing processes is too dangerous to expose without authentication
+	if app.basicAuthEnabled() {
+		r.HandleFunc("POST /api/process/{pid}/kill", app.killProcessHandler)
+	}
+
+	// Profiles reveal internals and are costly to take, so they are opt-in
+	if app.config.pprof {
+		r.HandleFunc("GET /debug/pprof/", pprof.Index)
+		r.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+		r.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+		r.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+		r.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	}
+
+	handler := app.requireBasicAuth(r)
+	if app.config.basePath == "" {
+		return handler
+	}
+
+	// Under -base-path every route moves below the prefix. The routes above
+	// still see their usual paths, and the bare prefix redirects to the
+	// dashboard.
+	base := http.NewServeMux()
+	base.Handle(app.config.basePath+"/", http.StripPrefix(app.config.basePath, handler))
+	base.Handle(app.config.basePath, http.RedirectHandler(app.config.basePath+"/", http.StatusMovedPermanently))
+	return base
+}
+
+// templateData is passed to index.html so the page follows the server's
+// configuration instead of guessing it
+type templateData struct {
+	// Prefix for every URL the page loads, e.g. /resmon, or empty
+	BasePath string
+
+	// Path of the WebSocket endpoint, including BasePath
+	WSPath string
+
+	// Milliseconds between snapshots
+	Interval int64
+
+	Hostname string
+	Title    string
+}
+
+func (app *application) serveHTMLHandler(w http.ResponseWriter, r *http.Request) {
+	// Shown until the first snapshot arrives; the page works without it
+	hostname := app.config.displayName
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+
+	title := "Resources Monitor"
+	if hostname != "" {
+		title = hostname + " - " + title
+	}
+
+	data := templateData{
+		BasePath: app.config.basePath,
+		WSPath:   app.config.basePath + "/ws",
+		Interval: app.config.interval.Milliseconds(),
+		Hostname: hostname,
+		Title:    title,
+	}
+
+	err := app.indexTemplate.Execute(w, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (app *application) wsHandler(w http.ResponseWriter, r *http.Request) {
+	// Hijacked WebSocket connections are not tracked by Shutdown(), so serve()
+	// waits on the WaitGroup for them instead
+	app.wg.Add(1)
+	defer app.wg.Done()
+
+	// The slot is taken before upgrading, so clients racing to connect
+	// cannot overshoot -max-connections between the check and the upgrade
+	connections := app.connections.Add(1)
+	defer app.connections.Add(-1)
+	if limit := app.config.maxConnections; limit > 0 && connections > int64(limit) {
+		app.logger.Warn("connection limit reached", "remote_addr", r.RemoteAddr, "max_connections", limit)
+		retryAfter := reconnectDelay(busyRetryAfter)
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Round(time.Second).Seconds())))
+		http.Error(w, "too many connections", http.StatusServiceUnavailable)
+		return
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		CheckOrigin:       app.checkOrigin,
+		EnableCompression: app.config.compress,
+	}
+
+	// Upgrade has already replied to the client when it fails
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	app.logger.Info("client connected", "remote_addr", r.RemoteAddr, "connections", connections)
+	defer func() {
+		// The slot is released by the deferred Add(-1) above, after this runs
+		app.logger.Info("client disconnected", "remote_addr", r.RemoteAddr, "duration", time.Since(start),
+			"connections", app.connections.Load()-1)
+	}()
+
+	// Only takes effect when the client negotiated permessage-deflate
+	conn.EnableWriteCompression(app.config.compress)
+
+	// The client can change its config at any time by sending a message,
+	// so the read loop below replaces cfg while snapshots are being sent
+	var cfgMu sync.Mutex
+	cfg, err := app.parseConnConfig(r.URL.Query())
+	if err != nil {
+		sendClose(conn, websocket.ClosePolicyViolation, err)
+		return
+	}
+
+	// Snapshots come from the shared collector; this connection only
+	// narrows each one down for its client
+	updates, latest := app.collector.subscribe()
+	defer app.collector.unsubscribe(updates)
+
+	// Helper function to send a snapshot as this client asked for it. A
+	// write that takes longer than the interval means the client cannot
+	// keep up, so the snapshot after it is skipped: the client falls back to
+	// every other snapshot instead of this goroutine spending all its time
+	// blocked on writes.
+	var lastSent time.Time
+	var throttled, skipNext bool
+	sendSnapshot := func(shared *Resources) error {
+		cfgMu.Lock()
+		cfg := cfg
+		cfgMu.Unlock()
+
+		if !cfg.due(lastSent, shared.Timestamp, app.config.interval) {
+			return nil
+		}
+		if skipNext {
+			skipNext = false
+			return nil
+		}
+		lastSent = shared.Timestamp
+
+		rs := app.clientView(shared, cfg.query)
+
+		msg, err := app.marshalSnapshot(&rs, cfg.jsonCase, cfg.format)
+		if err != nil {
+			return err
+		}
+
+		messageType := websocket.TextMessage
+		if cfg.format == "msgpack" {
+			messageType = websocket.BinaryMessage
+		}
+
+		// A client that stops reading would otherwise block this write, and
+		// the connection with it, once the socket buffers fill up
+		writeStart := time.Now()
+		_ = conn.SetWriteDeadline(writeStart.Add(app.config.wsWriteTimeout))
+		err = conn.WriteMessage(messageType, msg)
+		if err != nil {
+			return err
+		}
+
+		took := time.Since(writeStart)
+		skipNext = took > max(cfg.interval, app.config.interval)
+		if skipNext != throttled {
+			throttled = skipNext
+			if throttled {
+				app.logger.Warn("throttling slow client", "remote_addr", r.RemoteAddr, "write_time", took)
+			} else {
+				app.logger.Info("client caught up", "remote_addr", r.RemoteAddr, "write_time", took)
+			}
+		}
+		return nil
+	}
+
+	// Every pong pushes the read deadline forward, so a client that stops
+	// answering pings fails the read loop below once pongWait has passed.
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	// Reading is what processes pong and close frames, and it is how a dead
+	// or departed client is noticed. Every text message reconfigures the
+	// stream from the next snapshot on, on top of the query parameters and
+	// any earlier messages; an invalid one closes the connection.
+	readDone := make(chan struct{})
+	configured := make(chan struct{}, 1)
+	go func() {
+		defer close(readDone)
+		values := r.URL.Query()
+		for {
+			msgType, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msgType != websocket.TextMessage {
+				continue
+			}
+
+			next, err := configMessageValues(msg, values)
+			var nextCfg connConfig
+			if err == nil {
+				nextCfg, err = app.parseConnConfig(next)
+			}
+			if err != nil {
+				sendClose(conn, websocket.ClosePolicyViolation, err)
+				return
+			}
+
+			values = next
+			cfgMu.Lock()
+			cfg = nextCfg
+			cfgMu.Unlock()
+
+			select {
+			case configured <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	pingTicker := time.NewTicker(pingPeriod)
+	defer pingTicker.Stop()
+
+	// Give the client a moment to send its config before the first
+	// snapshot, so it does not get one shaped the wrong way
+	grace := time.NewTimer(configGrace)
+	select {
+	case <-configured:
+		grace.Stop()
+	case <-grace.C:
+	case <-readDone:
+		return
+	}
+
+	// Send the latest snapshot immediately, then each new one as the
+	// collector publishes it
+	if latest != nil {
+		if err := sendSnapshot(latest); err != nil {
+			app.snapshotFailed(conn, r, err)
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-app.shutdown:
+			// Going Away tells the client this is a planned restart, not a
+			// crash, so it can reconnect once the server is back
+			sendRetryClose(conn, websocket.CloseGoingAway, "server shutting down", reconnectDelay(shutdownRetryAfter))
+			return
+		case <-readDone:
+			return
+		case <-pingTicker.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+			if err != nil {
+				app.logger.Warn("client unreachable", "remote_addr", r.RemoteAddr, "error", err)
+				return
+			}
+		case rs := <-updates:
+			if err := sendSnapshot(rs); err != nil {
+				app.snapshotFailed(conn, r, err)
+				return
+			}
+		}
+	}
+}
+
+// checkOrigin decides whether a WebSocket upgrade or a process kill may
+// proceed, guarding against cross-site requests riding on the user's
+// credentials. Without -allowed-origins the Origin
+// must match the request's Host; an allowlist entry of "*" or -dev accepts
+// any origin. Requests without an Origin header come from non-browser clients
+// and pass.
+func (app *application) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || app.config.dev {
+		return true
+	}
+
+	if len(app.config.allowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		if err == nil && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+	}
+
+	for _, allowed := range app.config.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+
+	app.logger.Warn("rejected origin", "origin", origin, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+	return false
+}
+
+// collectResources gathers a single snapshot of the host's resource usage.
+// The process list is complete and unordered; clients sort and narrow it
+// down with selectProcesses.
+//
+// Every subsystem is collected independently: one that fails (load averages
+// are not available everywhere, for instance) is left zeroed and reported in
+// the snapshot's Errors instead of failing the whole snapshot.
+//
+// Collection gets 80% of the interval. Partitions and processes still
+// unread when that runs out are skipped and the snapshot is marked Partial,
+// so a host with thousands of processes cannot stall the collector. The
+// same happens when ctx is cancelled because the server is shutting down.
+func (app *application) collectResources(ctx context.Context, static staticInfo) Resources {
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, app.config.interval*8/10)
+	defer cancel()
+
+	// Derived from the boot time rather than asking the host again
+	var uptime uint64
+	if now := uint64(time.Now().Unix()); now > static.bootTime {
+		uptime = now - static.bootTime
+	}
+
+	rs := Resources{
+		SchemaVersion:      schemaVersion,
+		Timestamp:          start,
+		Unit:               "bytes",
+		Hostname:           static.hostname,
+		Uptime:             uptime,
+		UptimeHuman:        formatUptime(uptime),
+		BootTime:           static.bootTime,
+		Host:               static.host,
+		CPUCount:           static.cpuCount,
+		CPUFrequency:       []float64{},
+		Partitions:         []DiskPartition{},
+		DiskIO:             []DiskIOStat{},
+		Network:            []NetInterface{},
+		NetworkConnections: map[string]int{},
+		Sensors:            []TemperatureStat{},
+		Fans:               []FanStat{},
+		GPUs:               []GPUStat{},
+		Processes:          []ProcessInfo{},
+	}
+
+	errs := make(map[string]string)
+
+	v, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		errs["memory"] = err.Error()
+	} else {
+		rs.Memory = Memory{
+			Total:        float64(v.Total),
+			Free:         float64(v.Free),
+			Used:         float64(v.Used),
+			UsedPercent:  v.UsedPercent,
+			Available:    float64(v.Available),
+			Cached:       float64(v.Cached),
+			Buffers:      float64(v.Buffers),
+			Shared:       float64(v.Shared),
+			SReclaimable: float64(v.Sreclaimable),
+		}
+	}
+
+	sw, err := mem.SwapMemoryWithContext(ctx)
+	if err != nil {
+		errs["swap"] = err.Error()
+	} else {
+		rs.Swap = Swap{
+			Total:       float64(sw.Total),
+			Used:        float64(sw.Used),
+			Free:        float64(sw.Free),
+			UsedPercent: sw.UsedPercent,
+		}
+	}
+
+	// Windows has no load average. gopsutil approximates one from the
+	// processor queue length, sampled every 5 seconds by a goroutine that
+	// the first call starts with its own context. Collection contexts end
+	// with each snapshot, which would stop the sampling, so on Windows it
+	// gets one that is never cancelled. The averages start at zero and take
+	// a few minutes to settle.
+	loadCtx := ctx
+	if runtime.GOOS == "windows" {
+		loadCtx = context.WithoutCancel(ctx)
+	}
+	avg, err := load.AvgWithContext(loadCtx)
+	if err != nil {
+		errs["load"] = err.Error()
+	} else {
+		rs.LoadAverage = LoadAverage{
+			Load1:  avg.Load1,
+			Load5:  avg.Load5,
+			Load15: avg.Load15,
+		}
+		if static.cpuCount > 0 {
+			cores := float64(static.cpuCount)
+			rs.LoadAverage.Load1PerCore = avg.Load1 / cores
+			rs.LoadAverage.Load5PerCore = avg.Load5 / cores
+			rs.LoadAverage.Load15PerCore = avg.Load15 / cores
+		}
+	}
+
+	// Usage covers the time since the previous snapshot, so the reading
+	// spans the whole interval instead of blocking here for a fresh sample
+	usage, err := app.collector.cpu.sample(ctx, app.config.cpuSampleWindow)
+	if err != nil {
+		errs["cpu"] = err.Error()
+	} else {
+		rs.CPU = usage
+	}
+
+	infos, err := cpu.InfoWithContext(ctx)
+	if err != nil {
+		errs["cpuFrequency"] = err.Error()
+	}
+	for _, info := range infos {
+		rs.CPUFrequency = append(rs.CPUFrequency, info.Mhz)
+	}
+
+	partitions, err := disk.PartitionsWithContext(ctx, app.config.diskIncludeAll)
+	if err != nil {
+		errs["partitions"] = err.Error()
+	}
+	for _, partition := range partitions {
+		if ctx.Err() != nil {
+			break
+		}
+		if app.excludePartition(partition) {
+			continue
+		}
+
+		usage, err := disk.UsageWithContext(ctx, partition.Mountpoint)
+		if err != nil {
+			errs["partitions:"+partition.Mountpoint] = err.Error()
+			continue
+		}
+		rs.Partitions = append(rs.Partitions, DiskPartition{
+			Device:      partition.Device,
+			Mountpoint:  partition.Mountpoint,
+			Fstype:      partition.Fstype,
+			Total:       float64(usage.Total),
+			Used:        float64(usage.Used),
+			Free:        float64(usage.Free),
+			UsedPercent: usage.UsedPercent,
+
+			InodesTotal:       usage.InodesTotal,
+			InodesUsed:        usage.InodesUsed,
+			InodesFree:        usage.InodesFree,
+			InodesUsedPercent: usage.InodesUsedPercent,
+		})
+	}
+
+	rs.DiskTotal = sumPartitions(rs.Partitions)
+
+	diskIO, err := collectDiskIO(ctx)
+	if err != nil {
+		errs["diskIO"] = err.Error()
+	} else {
+		rs.DiskIO = diskIO
+	}
+
+	network, err := app.collectNetwork(ctx)
+	if err != nil {
+		errs["network"] = err.Error()
+	} else {
+		rs.Network = network
+	}
+
+	// GPU stats are polled by runGPUs on their own schedule
+	gpus, err := app.gpus.load()
+	if err != nil {
+		errs["gpus"] = err.Error()
+	}
+	rs.GPUs = gpus
+
+	// Connections, sensors and processes are the slow steps, so they are skipped
+	// outright once the deadline has passed or the client has gone
+	if ctx.Err() == nil {
+		connections, err := collectConnectionStates(ctx)
+		if err != nil {
+			errs["networkConnections"] = err.Error()
+		} else {
+			rs.NetworkConnections = connections
+		}
+	}
+
+	if ctx.Err() == nil {
+		rs.Sensors = collectTemperatures(ctx)
+
+		fans, err := collectFans()
+		if err != nil {
+			errs["fans"] = err.Error()
+		}
+		rs.Fans = fans
+	}
+
+	// Between reads of the process table the previous snapshot's list is
+	// sent again as it was. It is copied, since that snapshot is shared.
+	latest := app.collector.peek()
+	switch {
+	case app.config.noProcesses || ctx.Err() != nil:
+	case !app.processesDue(latest, start):
+		rs.Processes = slices.Clone(latest.Processes)
+		rs.ZombieCount = latest.ZombieCount
+		rs.ProcessesCapped = latest.ProcessesCapped
+		rs.processesAt = latest.processesAt
+	default:
+		processes, capped, err := app.listProcesses(ctx)
+		rs.ProcessesCapped = capped
+		if err != nil {
+			errs["processes"] = err.Error()
+		} else {
+			rs.Processes = app.hideProcesses(collectProcesses(ctx, processes, uint64(rs.Memory.Total)))
+			rs.processesAt = time.Now()
+			app.redactCmdlines(rs.Processes)
+			truncateCmdlines(rs.Processes, app.config.cmdlineMax)
+			for _, p := range rs.Processes {
+				if p.Zombie {
+					rs.ZombieCount++
+				}
+			}
+		}
+	}
+
+	rs.Partial = ctx.Err() != nil
+	rs.CollectionDurationMs = time.Since(start).Milliseconds()
+
+	if len(errs) > 0 {
+		rs.Errors = errs
+	}
+
+	return rs
+}
+
+// staticInfo holds the parts of a snapshot that stay the same for as long
+// as the host is up
+type staticInfo struct {
+	hostname string
+	bootTime uint64
+	host     HostInfo
+	cpuCount int
+}
+
+// collectStaticInfo reads the hostname, boot time, platform details and
+// logical core count. A non-empty displayName is reported as the hostname
+// instead.
+func collectStaticInfo(displayName string) (staticInfo, error) {
+	hostname := displayName
+	if hostname == "" {
+		var err error
+		hostname, err = os.Hostname()
+		if err != nil {
+			return staticInfo{}, err
+		}
+	}
+
+	info, err := host.Info()
+	if err != nil {
+		return staticInfo{}, err
+	}
+
+	cpuCount, err := cpu.Counts(true)
+	if err != nil {
+		return staticInfo{}, err
+	}
+
+	return staticInfo{
+		hostname: hostname,
+		bootTime: info.BootTime,
+		host: HostInfo{
+			OS:                   info.OS,
+			Platform:             info.Platform,
+			PlatformVersion:      info.PlatformVersion,
+			KernelVersion:        info.KernelVersion,
+			KernelArch:           info.KernelArch,
+			VirtualizationSystem: info.VirtualizationSystem,
+		},
+		cpuCount: cpuCount,
+	}, nil
+}
+
+// processesDue reports whether a snapshot starting at now should read the
+// process table afresh rather than repeat the list in latest
+func (app *application) processesDue(latest *Resources, now time.Time) bool {
+	if app.config.processInterval == 0 || latest == nil || latest.processesAt.IsZero() {
+		return true
+	}
+
+	// A list cut short by the collection deadline is read again on the
+	// next tick rather than repeated without its Partial flag
+	if latest.Partial {
+		return true
+	}
+
+	// Ticks drift by a few milliseconds, so a list read almost a full
+	// process interval ago is due rather than kept one tick too long
+	return now.Sub(latest.processesAt) >= app.config.processInterval-app.config.interval/2
+}
+
+// listProcesses returns the processes to read for a snapshot. Under
+// -max-process-scan it returns no more than that many and reports capped
+// when some were left out. Those kept have the highest PIDs, which are
+// usually the newest and so the likeliest culprits on a host flooded with
+// processes; sorting PIDs costs nothing next to reading the processes.
+func (app *application) listProcesses(ctx context.Context) (processes []*process.Process, capped bool, err error) {
+	limit := app.config.maxProcessScan
+	if limit == 0 {
+		processes, err = process.ProcessesWithContext(ctx)
+		return processes, false, err
+	}
+
+	pids, err := process.PidsWithContext(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(pids) > limit {
+		slices.SortFunc(pids, func(a, b int32) int { return cmp.Compare(b, a) })
+		pids = pids[:limit]
+		capped = true
+	}
+
+	processes = make([]*process.Process, 0, len(pids))
+	for _, pid := range pids {
+		// An error means the process exited after the PIDs were listed
+		p, err := process.NewProcessWithContext(ctx, pid)
+		if err != nil {
+			continue
+		}
+		processes = append(processes, p)
+	}
+	return processes, capped, nil
+}
+
+// collectProcesses gathers details for every process. Each process costs
+// several syscalls, so the work is spread over a pool of GOMAXPROCS workers.
+// Processes that exit or cannot be read mid-collection are left out, and so
+// are those not yet handed to a worker when ctx is done.
+func collectProcesses(ctx context.Context, processes []*process.Process, totalMemory uint64) []ProcessInfo {
+	infos := make([]ProcessInfo, len(processes))
+	found := make([]bool, len(processes))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range runtime.GOMAXPROCS(0) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				infos[i], found[i] = processInfo(ctx, processes[i], totalMemory)
+			}
+		}()
+	}
+
+feed:
+	for i := range processes {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	processInfos := make([]ProcessInfo, 0, len(processes))
+	for i, info := range infos {
+		if found[i] {
+			processInfos = append(processInfos, info)
+		}
+	}
+	return processInfos
+}
+
+// processInfo reads the details of a single process. It reports false when
+// the process can no longer be inspected.
+func processInfo(ctx context.Context, p *process.Process, totalMemory uint64) (ProcessInfo, bool) {
+	name, err := p.NameWithContext(ctx)
+	if err != nil {
+		return ProcessInfo{}, false
+	}
+
+	memInfo, err := p.MemoryInfoWithContext(ctx)
+	if err != nil {
+		return ProcessInfo{}, false
+	}
+
+	// Derive the memory share from the total already collected, rather than
+	// p.MemoryPercent() which reads the system memory again for every process.
+	var memPercent float32
+	if totalMemory > 0 {
+		memPercent = float32(100 * float64(memInfo.RSS) / float64(totalMemory))
+	}
+
+	cmdLine, _ := p.CmdlineWithContext(ctx)
+	statuses, _ := p.StatusWithContext(ctx)
+	username, _ := p.UsernameWithContext(ctx)
+	ppid, _ := p.PpidWithContext(ctx)
+
+	// Reading another user's file descriptors usually needs privileges, so
+	// -1 marks a count that could not be read rather than dropping the process.
+	numFDs, err := p.NumFDsWithContext(ctx)
+	if err != nil {
+		numFDs = -1
+	}
+	numThreads, err := p.NumThreadsWithContext(ctx)
+	if err != nil {
+		numThreads = -1
+	}
+	createTime, _ := p.CreateTimeWithContext(ctx)
+
+	// p.CPUPercent() divides the CPU time by the whole lifetime of the
+	// process, which says little about what it is doing now. The cumulative
+	// time is kept so rateTracker can work out usage since the previous
+	// snapshot; until then the lifetime average stands in, marked
+	// CPUPreliminary.
+	var cpuTime, cpuPercent float64
+	if times, err := p.TimesWithContext(ctx); err == nil {
+		cpuTime = times.User + times.System
+		if createTime > 0 {
+			if lifetime := time.Since(time.UnixMilli(createTime)).Seconds(); lifetime > 0 {
+				cpuPercent = 100 * cpuTime / lifetime
+			}
+		}
+	}
+
+	// CreateTime is in Unix milliseconds; an unknown start time leaves the
+	// age at zero rather than counting from 1970
+	var ageSeconds int64
+	if createTime > 0 {
+		ageSeconds = max(0, int64(time.Since(time.UnixMilli(createTime)).Seconds()))
+	}
+
+	// Not every platform exposes per-process I/O, and other users'
+	// processes usually need privileges; both read as zero
+	var readBytes, writeBytes uint64
+	if io, err := p.IOCountersWithContext(ctx); err == nil {
+		readBytes, writeBytes = io.ReadBytes, io.WriteBytes
+	}
+
+	status := firstOrEmpty(statuses)
+
+	return ProcessInfo{
+		PID:            p.Pid,
+		PPID:           ppid,
+		Name:           name,
+		CPUPercent:     cpuPercent,
+		CPUPreliminary: true,
+		CPUTimeSeconds: cpuTime,
+		MemoryMB:       float64(memInfo.RSS) / 1024 / 1024,
+		VirtualMB:      float64(memInfo.VMS) / 1024 / 1024,
+		MemoryPercent:  memPercent,
+		Status:         status,
+		Zombie:         status == process.Zombie,
+		Username:       username,
+		Cmdline:        cmdLine,
+		NumFDs:         numFDs,
+		NumThreads:     numThreads,
+		CreateTime:     createTime,
+		AgeSeconds:     ageSeconds,
+		ReadBytes:      readBytes,
+		WriteBytes:     writeBytes,
+	}, true
+}
+
+// collectNetwork reads the cumulative I/O counters of every network
+// interface, leaving out loopback interfaces unless -net-loopback is set.
+func (app *application) collectNetwork(ctx context.Context) ([]NetInterface, error) {
+	counters, err := psnet.IOCountersWithContext(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Loopback interfaces are always identified, even when they are listed,
+	// so they can be kept out of NetworkTotal
+	loopback := make(map[string]bool)
+	interfaces, err := psnet.InterfacesWithContext(ctx)
+	if err != nil && !app.config.netLoopback {
+		return nil, err
+	}
+	for _, iface := range interfaces {
+		if slices.Contains(iface.Flags, "loopback") {
+			loopback[iface.Name] = true
+		}
+	}
+
+	var network []NetInterface
+	for _, c := range counters {
+		if loopback[c.Name] && !app.config.netLoopback {
+			continue
+		}
+		network = append(network, NetInterface{
+			Name:        c.Name,
+			BytesSent:   c.BytesSent,
+			BytesRecv:   c.BytesRecv,
+			PacketsSent: c.PacketsSent,
+			PacketsRecv: c.PacketsRecv,
+			loopback:    loopback[c.Name],
+		})
+	}
+
+	return network, nil
+}
+
+// collectTemperatures reads the host's temperature sensors. Sensors are not
+// available on every platform (or inside most VMs and containers), so any
+// failure yields an empty slice instead of an error. Some sensors failing
+// still returns the ones that could be read.
+func collectTemperatures(ctx context.Context) []TemperatureStat {
+	stats, _ := sensors.TemperaturesWithContext(ctx)
+
+	temperatures := make([]TemperatureStat, 0, len(stats))
+	for _, t := range stats {
+		temperatures = append(temperatures, TemperatureStat{
+			SensorKey:   t.SensorKey,
+			Temperature: t.Temperature,
+			High:        t.High,
+			Critical:    t.Critical,
+		})
+	}
+	return temperatures
+}
+
+// excludePartition reports whether a partition is missing from -disk-mounts
+// or matches -disk-exclude-fstype or -disk-exclude-mount. Exclusions are
+// applied after -disk-include-all has widened the list, so an excluded
+// partition is always left out. Excluded partitions never reach disk.Usage,
+// which matters for network filesystems that are slow to answer.
+func (app *application) excludePartition(p disk.PartitionStat) bool {
+	if len(app.config.diskMounts) > 0 && !slices.Contains(app.config.diskMounts, p.Mountpoint) {
+		return true
+	}
+	return matchAny(app.config.diskExcludeFstype, p.Fstype) || matchAny(app.config.diskExcludeMount, p.Mountpoint)
+}
+
+// roundPercentages rounds every percentage in a snapshot to places decimal
+// places. Full float precision is false precision for values read once a
+// second, and makes every message bigger.
+func roundPercentages(rs *Resources, places int) {
+	if places < 0 {
+		return
+	}
+
+	rs.Memory.UsedPercent = roundTo(rs.Memory.UsedPercent, places)
+	rs.Swap.UsedPercent = roundTo(rs.Swap.UsedPercent, places)
+	rs.CPU.UsedPercent = roundTo(rs.CPU.UsedPercent, places)
+	t := &rs.CPU.Times
+	t.User, t.System, t.Idle = roundTo(t.User, places), roundTo(t.System, places), roundTo(t.Idle, places)
+	t.Iowait, t.Steal, t.Nice = roundTo(t.Iowait, places), roundTo(t.Steal, places), roundTo(t.Nice, places)
+	for i := range rs.CPU.PerCore {
+		rs.CPU.PerCore[i] = roundTo(rs.CPU.PerCore[i], places)
+	}
+	for i := range rs.Partitions {
+		p := &rs.Partitions[i]
+		p.UsedPercent = roundTo(p.UsedPercent, places)
+		p.InodesUsedPercent = roundTo(p.InodesUsedPercent, places)
+	}
+	rs.DiskTotal.UsedPercent = roundTo(rs.DiskTotal.UsedPercent, places)
+	for i := range rs.GPUs {
+		rs.GPUs[i].UtilizationPercent = roundTo(rs.GPUs[i].UtilizationPercent, places)
+	}
+	roundProcesses(rs.Processes, places)
 }
 
-func main() {
-	app := &application{
-		port: 8080,
+// roundProcesses rounds the percentages of every process, as for
+// roundPercentages
+func roundProcesses(processes []ProcessInfo, places int) {
+	if places < 0 {
+		return
 	}
 
-	err := app.serve()
-	if err != nil {
-		log.Fatal(err)
+	for i := range processes {
+		p := &processes[i]
+		p.CPUPercent = roundTo(p.CPUPercent, places)
+		p.MemoryPercent = float32(roundTo(float64(p.MemoryPercent), places))
 	}
 }
 
-func (app *application) routes() http.Handler {
-	r := http.NewServeMux()
+// memUnits maps each -mem-unit to its size in bytes. MB and GB are binary,
+// like MemoryMB.
+var memUnits = map[string]float64{
+	"bytes": 1,
+	"MB":    1 << 20,
+	"GB":    1 << 30,
+}
+
+// convertSizes converts the memory, swap and disk sizes of a copied snapshot
+// from bytes to unit, rounding them like the percentages. Partitions is
+// shared with the collector, so it is copied before being changed.
+func convertSizes(rs *Resources, unit string, places int) {
+	if rs.Unit == unit {
+		return
+	}
+
+	scale := memUnits[unit]
+	convert := func(sizes ...*float64) {
+		for _, size := range sizes {
+			*size /= scale
+			if places >= 0 {
+				*size = roundTo(*size, places)
+			}
+		}
+	}
+
+	m := &rs.Memory
+	convert(&m.Total, &m.Available, &m.Used, &m.Free, &m.Cached, &m.Buffers, &m.Shared, &m.SReclaimable)
+	convert(&rs.Swap.Total, &rs.Swap.Used, &rs.Swap.Free)
+	convert(&rs.DiskTotal.Total, &rs.DiskTotal.Used, &rs.DiskTotal.Free)
+	rs.Partitions = slices.Clone(rs.Partitions)
+	for i := range rs.Partitions {
+		p := &rs.Partitions[i]
+		convert(&p.Total, &p.Used, &p.Free)
+	}
+	rs.Unit = unit
+}
+
+// roundTo rounds v to places decimal places
+func roundTo(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
+
+// sumPartitions adds up the space on every partition. A device mounted more
+// than once (bind mounts, btrfs subvolumes) is counted only the first time.
+func sumPartitions(partitions []DiskPartition) Disk {
+	var total Disk
+	seen := make(map[string]bool)
+	for _, p := range partitions {
+		if seen[p.Device] {
+			continue
+		}
+		seen[p.Device] = true
+
+		total.Total += p.Total
+		total.Used += p.Used
+		total.Free += p.Free
+	}
+
+	// Same formula as disk.Usage, so the total agrees with its partitions
+	if total.Used+total.Free > 0 {
+		total.UsedPercent = total.Used / (total.Used + total.Free) * 100
+	}
+	return total
+}
 
-	staticFS, err := fs.Sub(embeddedFiles, "static")
+// collectDiskIO reads the cumulative I/O counters of every block device,
+// ordered by device name.
+func collectDiskIO(ctx context.Context) ([]DiskIOStat, error) {
+	counters, err := disk.IOCountersWithContext(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	r.Handle("/static/", http.StripPrefix("/static", http.FileServer(http.FS(staticFS))))
-	r.HandleFunc("/", app.serveHTMLHandler)
-	r.HandleFunc("/ws", app.wsHandler)
+	diskIO := make([]DiskIOStat, 0, len(counters))
+	for name, c := range counters {
+		diskIO = append(diskIO, DiskIOStat{
+			Name:       name,
+			ReadBytes:  c.ReadBytes,
+			WriteBytes: c.WriteBytes,
+			ReadCount:  c.ReadCount,
+			WriteCount: c.WriteCount,
+			IoTime:     c.IoTime,
+		})
+	}
 
-	return r
+	sort.Slice(diskIO, func(i, j int) bool {
+		return diskIO[i].Name < diskIO[j].Name
+	})
+
+	return diskIO, nil
 }
 
-func (app *application) serveHTMLHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.ParseFS(embeddedFiles, "static/index.html")
+// rateTracker remembers the cumulative counters of the previous snapshot so
+// the next one can report per-second rates.
+type rateTracker struct {
+	at      time.Time
+	network map[string]NetInterface
+	diskIO  map[string]DiskIOStat
+
+	// Processes are tracked from when they were read, which is not every
+	// snapshot under -process-interval
+	processesAt time.Time
+	processes   map[int32]ProcessInfo
+}
+
+// update fills in the rates of rs from the previous snapshot and then keeps
+// rs as the new baseline. Devices, interfaces and processes that were not in
+// the previous snapshot keep zero rates. A process only matches when its
+// start time matches too, so a reused PID starts again from zero.
+func (t *rateTracker) update(rs *Resources, now time.Time) {
+	if !t.at.IsZero() {
+		elapsed := now.Sub(t.at)
+
+		for i := range rs.Network {
+			n := &rs.Network[i]
+			p, ok := t.network[n.Name]
+			if !ok {
+				continue
+			}
+			n.BytesSentPerSec = ratePerSec(n.BytesSent, p.BytesSent, elapsed)
+			n.BytesRecvPerSec = ratePerSec(n.BytesRecv, p.BytesRecv, elapsed)
+			n.PacketsSentPerSec = ratePerSec(n.PacketsSent, p.PacketsSent, elapsed)
+			n.PacketsRecvPerSec = ratePerSec(n.PacketsRecv, p.PacketsRecv, elapsed)
+		}
+
+		// Summed from the same rates, so the total always matches the
+		// interfaces listed
+		for _, n := range rs.Network {
+			if n.loopback {
+				continue
+			}
+			rs.NetworkTotal.BytesSentPerSec += n.BytesSentPerSec
+			rs.NetworkTotal.BytesRecvPerSec += n.BytesRecvPerSec
+			rs.NetworkTotal.PacketsSentPerSec += n.PacketsSentPerSec
+			rs.NetworkTotal.PacketsRecvPerSec += n.PacketsRecvPerSec
+		}
+
+		for i := range rs.DiskIO {
+			d := &rs.DiskIO[i]
+			p, ok := t.diskIO[d.Name]
+			if !ok {
+				continue
+			}
+			d.ReadBytesPerSec = ratePerSec(d.ReadBytes, p.ReadBytes, elapsed)
+			d.WriteBytesPerSec = ratePerSec(d.WriteBytes, p.WriteBytes, elapsed)
+			d.ReadCountPerSec = ratePerSec(d.ReadCount, p.ReadCount, elapsed)
+			d.WriteCountPerSec = ratePerSec(d.WriteCount, p.WriteCount, elapsed)
+			d.IoTimePerSec = ratePerSec(d.IoTime, p.IoTime, elapsed)
+		}
+	}
+
+	// A repeated process list already has its rates and must not become
+	// the baseline again
+	if !rs.processesAt.Equal(t.processesAt) {
+		t.updateProcesses(rs)
+	}
+
+	t.network = make(map[string]NetInterface, len(rs.Network))
+	for _, n := range rs.Network {
+		t.network[n.Name] = n
+	}
+	t.diskIO = make(map[string]DiskIOStat, len(rs.DiskIO))
+	for _, d := range rs.DiskIO {
+		t.diskIO[d.Name] = d
+	}
+	t.at = now
+}
+
+// updateProcesses fills in the process rates of rs from the previous read of
+// the process table and keeps rs's processes as the new baseline
+func (t *rateTracker) updateProcesses(rs *Resources) {
+	if !t.processesAt.IsZero() {
+		elapsed := rs.processesAt.Sub(t.processesAt)
+
+		for i := range rs.Processes {
+			proc := &rs.Processes[i]
+			p, ok := t.processes[proc.PID]
+			if !ok || p.CreateTime != proc.CreateTime {
+				continue
+			}
+			proc.CPUPercent, proc.CPUPreliminary = processCPUPercent(proc.CPUTimeSeconds, p.CPUTimeSeconds, elapsed), false
+			proc.ReadBytesPerSec = ratePerSec(proc.ReadBytes, p.ReadBytes, elapsed)
+			proc.WriteBytesPerSec = ratePerSec(proc.WriteBytes, p.WriteBytes, elapsed)
+		}
+	}
+
+	t.processes = make(map[int32]ProcessInfo, len(rs.Processes))
+	for _, p := range rs.Processes {
+		t.processes[p.PID] = p
+	}
+	t.processesAt = rs.processesAt
+}
+
+// processCPUPercent works out a process's CPU usage, where 100 is one fully
+// busy core, from its CPU seconds at two readings elapsed apart
+func processCPUPercent(cur, prev float64, elapsed time.Duration) float64 {
+	if cur < prev || elapsed <= 0 {
+		return 0
+	}
+	return 100 * (cur - prev) / elapsed.Seconds()
+}
+
+// ratePerSec converts the growth of a cumulative counter into a per-second
+// rate. A counter that went backwards (reset or wrapped) reports zero.
+func ratePerSec(cur, prev uint64, elapsed time.Duration) float64 {
+	if cur < prev || elapsed <= 0 {
+		return 0
+	}
+	return float64(cur-prev) / elapsed.Seconds()
+}
+
+func (app *application) snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	query, err := parseProcessQuery(r.URL.Query())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	shared, err := app.collector.current(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
-	err = tmpl.Execute(w, nil)
+
+	err = app.writeJSON(w, http.StatusOK, app.clientView(shared, query))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
-func (app *application) wsHandler(w http.ResponseWriter, r *http.Request) {
-	upgrader := websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-		CheckOrigin:     func(r *http.Request) bool { return true },
+// disksHandler returns just the partitions and disk I/O of the latest
+// snapshot, for clients that watch storage and poll far less often than the
+// stream runs
+func (app *application) disksHandler(w http.ResponseWriter, r *http.Request) {
+	shared, err := app.collector.current(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	rs := *shared
+	convertSizes(&rs, app.config.memUnit, app.config.round)
+
+	err = app.writeJSON(w, http.StatusOK, Disks{Partitions: rs.Partitions, DiskIO: rs.DiskIO})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer conn.Close()
+}
 
-	hostname, err := os.Hostname()
+func (app *application) connectionsHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, map[string]int64{"connections": app.connections.Load()})
 	if err != nil {
-		sendClose(conn, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+}
 
-	// Helper function to gather and send resource info
-	sendSnapshot := func() error {
-		uptime, err := host.Uptime()
-		if err != nil {
-			return err
+// marshalSnapshot encodes a snapshot for a WebSocket client, as JSON or,
+// when format is msgpack, MessagePack. While it is larger than
+// -max-payload-bytes the process list is halved and Truncated is set. The
+// rest of the snapshot is small, so it is sent as is even if it alone
+// exceeds the limit.
+func (app *application) marshalSnapshot(rs *Resources, jsonCase, format string) ([]byte, error) {
+	encode := func() ([]byte, error) {
+		if format == "msgpack" {
+			return marshalMsgpack(rs, jsonCase)
 		}
+		return marshalJSONCase(rs, jsonCase)
+	}
 
-		v, err := mem.VirtualMemory()
-		if err != nil {
-			return err
-		}
+	msg, err := encode()
+	if err != nil {
+		return nil, err
+	}
 
-		avg, err := load.Avg()
-		if err != nil {
-			return err
-		}
+	limit := app.config.maxPayloadBytes
+	for limit > 0 && len(msg) > limit && len(rs.Processes) > 0 {
+		rs.Processes = rs.Processes[:len(rs.Processes)/2]
+		rs.Truncated = true
 
-		partitions, err := disk.Partitions(false)
+		msg, err = encode()
 		if err != nil {
-			return err
+			return nil, err
 		}
+	}
+	return msg, nil
+}
 
-		var diskPartitions []DiskPartition
-		for _, partition := range partitions {
-			usage, err := disk.Usage(partition.Mountpoint)
-			if err != nil {
-				continue
-			}
-			diskPartitions = append(diskPartitions, DiskPartition{
-				Device:      partition.Device,
-				Mountpoint:  partition.Mountpoint,
-				Fstype:      partition.Fstype,
-				Total:       usage.Total,
-				Used:        usage.Used,
-				Free:        usage.Free,
-				UsedPercent: usage.UsedPercent,
-			})
-		}
-
-		processes, err := process.Processes()
-		if err != nil {
-			return err
-		}
+// writeJSON sends data as a JSON response with the given status code
+func (app *application) writeJSON(w http.ResponseWriter, status int, data any) error {
+	js, err := app.marshalJSON(data)
+	if err != nil {
+		return err
+	}
 
-		var processInfos []ProcessInfo
-		for _, p := range processes {
-			name, err := p.Name()
-			if err != nil {
-				continue
-			}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(js)
+	return err
+}
 
-			cpuPercent, _ := p.CPUPercent()
-			memInfo, err := p.MemoryInfo()
-			if err != nil {
-				continue
-			}
+// sendClose sends a proper close message
+func sendClose(conn *websocket.Conn, code int, err error) {
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, err.Error()), time.Now().Add(writeWait))
+}
 
-			cmdLine, _ := p.Cmdline()
-			memPercent, _ := p.MemoryPercent()
-			status, _ := p.Status()
-			username, _ := p.Username()
-
-			processInfos = append(processInfos, ProcessInfo{
-				PID:           p.Pid,
-				Name:          name,
-				CPUPercent:    cpuPercent,
-				MemoryMB:      float64(memInfo.RSS) / 1024 / 1024,
-				MemoryPercent: memPercent,
-				Status:        firstOrEmpty(status),
-				Username:      username,
-				Cmdline:       cmdLine,
-			})
-		}
-
-		sort.Slice(processInfos, func(i, j int) bool {
-			return processInfos[i].CPUPercent > processInfos[j].CPUPercent
-		})
+// closeReason is the reason sent in a close message that asks the client to
+// wait before reconnecting
+type closeReason struct {
+	Reason       string `json:"reason"`
+	RetryAfterMs int64  `json:"retryAfterMs"`
+}
 
-		rs := Resources{
-			Hostname: hostname,
-			Uptime:   uptime,
-			Memory: Memory{
-				Total:       v.Total,
-				Free:        v.Free,
-				Used:        v.Used,
-				UsedPercent: v.UsedPercent,
-				Available:   v.Available,
-			},
-			LoadAverage: LoadAverage{
-				Load1:  avg.Load1,
-				Load5:  avg.Load5,
-				Load15: avg.Load15,
-			},
-			Partitions: diskPartitions,
-			Processes:  processInfos,
-		}
-
-		return conn.WriteJSON(rs)
-	}
-
-	// Send the first snapshot immediately
-	if err := sendSnapshot(); err != nil {
-		sendClose(conn, err)
-		return
-	}
+// sendRetryClose sends a close message whose reason is a JSON closeReason,
+// so well-behaved clients know how long to back off
+func sendRetryClose(conn *websocket.Conn, code int, reason string, retryAfter time.Duration) {
+	js, _ := json.Marshal(closeReason{Reason: reason, RetryAfterMs: retryAfter.Milliseconds()})
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, string(js)), time.Now().Add(writeWait))
+}
 
-	// Loop every second (1s delay after each send)
-	for {
-		select {
-		case <-r.Context().Done():
-			log.Println("client disconnected")
-			return
-		case <-time.After(1 * time.Second):
-			if err := sendSnapshot(); err != nil {
-				sendClose(conn, err)
-				return
-			}
-		}
-	}
+// reconnectDelay adds up to base again of random jitter to base, so clients
+// dropped at the same moment do not all come back at once
+func reconnectDelay(base time.Duration) time.Duration {
+	return base + rand.N(base)
 }
 
-// sendClose sends a proper close message
-func sendClose(conn *websocket.Conn, err error) {
-	_ = conn.WriteMessage(websocket.CloseMessage,
-		websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error()))
+// snapshotFailed logs a snapshot that could not be sent and tells the client
+// why. After a write timeout the connection is unusable, so it is dropped
+// without a close frame.
+func (app *application) snapshotFailed(conn *websocket.Conn, r *http.Request, err error) {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		app.logger.Warn("client too slow", "remote_addr", r.RemoteAddr, "error", err)
+		return
+	}
+
+	app.logger.Warn("snapshot error", "remote_addr", r.RemoteAddr, "error", err)
+	sendClose(conn, websocket.CloseInternalServerErr, err)
 }
 
 // helper to safely extract first rune from process.Status()
@@ -228,19 +1789,126 @@ func firstOrEmpty(s []string) string {
 	return ""
 }
 
+// helper to render an uptime in seconds as e.g. "3d 4h 12m", leaving out
+// zero units
+func formatUptime(seconds uint64) string {
+	days := seconds / 86400
+	hours := seconds % 86400 / 3600
+	minutes := seconds % 3600 / 60
+
+	var parts []string
+	if days > 0 {
+		parts = append(parts, fmt.Sprintf("%dd", days))
+	}
+	if hours > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", hours))
+	}
+	if minutes > 0 || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf("%dm", minutes))
+	}
+	return strings.Join(parts, " ")
+}
+
+// helper to split a comma-separated flag value, ignoring blank entries
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// background runs fn in a goroutine that serve() waits for on shutdown. A
+// panic in fn is logged rather than taking the server down.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprintf("%v", err))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// listen opens the listener the server accepts connections on: the Unix
+// socket from -unix-socket if set, otherwise TCP on -host and -port
+func (app *application) listen() (net.Listener, error) {
+	if app.config.unixSocket == "" {
+		return net.Listen("tcp", listenAddr(app.config.host, app.config.port))
+	}
+
+	// A file left at the path is most likely the socket of a server that
+	// crashed, but could be another server that is still running, so it is
+	// only replaced when asked to
+	_, err := os.Lstat(app.config.unixSocket)
+	if err == nil {
+		if !app.config.unixSocketForce {
+			return nil, fmt.Errorf("%s already exists; remove it or use -unix-socket-force", app.config.unixSocket)
+		}
+		err = os.Remove(app.config.unixSocket)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Closing a listener created by net.Listen removes the socket file, so
+	// Shutdown() cleans it up
+	return net.Listen("unix", app.config.unixSocket)
+}
+
+// listenAddr joins -host and -port into a TCP address, bracketing IPv6
+// literals such as ::1. An empty host binds every interface.
+func listenAddr(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
 func (app *application) serve() error {
+	listener, err := app.listen()
+	if err != nil {
+		return err
+	}
+
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", app.port),
+		Addr:         listener.Addr().String(),
 		Handler:      app.routes(),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+
+	// With -autocert-domain certificates come from Let's Encrypt, and a
+	// second server on port 80 answers its challenges
+	certManager := app.autocertManager()
+	var challengeSrv *http.Server
+	if certManager != nil {
+		srv.TLSConfig = certManager.TLSConfig()
+		challengeSrv = app.serveACMEChallenges(certManager)
 	}
 
 	// Create a shutdownError channel. We will use this to receive any errors returned
 	// by the graceful Shutdown() function.
 	shutdownError := make(chan error)
 
+	// Start collecting before accepting connections, so the first client
+	// gets a snapshot without waiting a full interval.
+	app.background(app.runCollector)
+	app.background(app.runGPUs)
+	app.background(app.runAlerts)
+	app.background(app.runStatsD)
+	app.background(app.runMetricsFile)
+	for _, h := range app.remotes {
+		app.background(func() { app.runRemote(h) })
+	}
+
 	// Start a background goroutine.
 	go func() {
 		// Create a quit channel which carries os.Signal values.
@@ -255,7 +1923,10 @@ func (app *application) serve() error {
 		// received.
 		s := <-quit
 
-		log.Printf("shutting down server: %s", s.String())
+		app.logger.Info("shutting down server", "signal", s.String())
+
+		// Tell the WebSocket handlers to stop streaming.
+		close(app.shutdown)
 
 		// Create a context with a 20-second timeout.
 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
@@ -264,13 +1935,16 @@ func (app *application) serve() error {
 		// Call Shutdown() on the server like before, but now we only send on the
 		// shutdownError channel if it returns an error.
 		err := srv.Shutdown(ctx)
+		if challengeSrv != nil {
+			err = errors.Join(err, challengeSrv.Shutdown(ctx))
+		}
 		if err != nil {
 			shutdownError <- err
 		}
 
 		// Log a message to say that we're waiting for any background goroutines to
 		// complete their tasks.
-		log.Printf("completing background tasks: %s", srv.Addr)
+		app.logger.Info("completing background tasks", "addr", srv.Addr)
 
 		// Call Wait() to block until our WaitGroup counter is zero --- essentially
 		// blocking until the background goroutines have finished. Then we return nil on
@@ -280,14 +1954,22 @@ func (app *application) serve() error {
 		shutdownError <- nil
 	}()
 
-	log.Printf("starting server: %s", srv.Addr)
-
-	// Calling Shutdown() on our server will cause ListenAndServe() to immediately
+	// Calling Shutdown() on our server will cause Serve() to immediately
 	// return a http.ErrServerClosed error. So if we see this error, it is actually a
 	// good thing and an indication that the graceful shutdown has started. So we check
 	// specifically for this, only returning the error if it is NOT http.ErrServerClosed.
-	err := srv.ListenAndServe()
-	if errors.Is(err, http.ErrServerClosed) {
+	// ServeTLS() behaves exactly the same way.
+	app.logger.Info("starting server", "addr", srv.Addr, "tls", app.config.tlsCert != "" || certManager != nil)
+	switch {
+	case certManager != nil:
+		// The certificate comes from srv.TLSConfig rather than files
+		err = srv.ServeTLS(listener, "", "")
+	case app.config.tlsCert != "":
+		err = srv.ServeTLS(listener, app.config.tlsCert, app.config.tlsKey)
+	default:
+		err = srv.Serve(listener)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
 
@@ -298,36 +1980,116 @@ func (app *application) serve() error {
 
 	// At this point we know that the graceful shutdown completed successfully and we
 	// log a "stopped server" message.
-	log.Printf("stopped server: %s", srv.Addr)
+	app.logger.Info("stopped server", "addr", srv.Addr)
 
 	return nil
 }
 
+type HostInfo struct {
+	// Operating system, e.g. "linux" or "windows"
+	OS string `json:"os"`
+
+	// Distribution or edition, e.g. "ubuntu", and its version
+	Platform        string `json:"platform"`
+	PlatformVersion string `json:"platformVersion"`
+
+	KernelVersion string `json:"kernelVersion"`
+	KernelArch    string `json:"kernelArch"`
+
+	// Hypervisor or container runtime the host runs under, if detected
+	VirtualizationSystem string `json:"virtualizationSystem"`
+}
+
 type Memory struct {
 	// Total amount of RAM on this system
-	Total uint64 `json:"total"`
+	Total float64 `json:"total"`
 
 	// RAM available for programs to allocate
-	Available uint64 `json:"available"`
+	Available float64 `json:"available"`
 
 	// RAM used by programs
-	Used uint64 `json:"used"`
+	Used float64 `json:"used"`
 
 	// Percentage of RAM used by programs
 	UsedPercent float64 `json:"usedPercent"`
 
 	// This is the kernel's notion of free memory;
-	Free uint64 `json:"free"`
+	Free float64 `json:"free"`
+
+	// Page cache and buffers for block devices. This field and the ones
+	// below break down memory the kernel holds on to; only Linux reports
+	// all of them, and they are omitted where the platform does not.
+	Cached  float64 `json:"cached,omitempty"`
+	Buffers float64 `json:"buffers,omitempty"`
+
+	// Shared memory, including tmpfs
+	Shared float64 `json:"shared,omitempty"`
+
+	// Kernel slab memory that can be reclaimed under pressure
+	SReclaimable float64 `json:"sReclaimable,omitempty"`
+}
+
+type Swap struct {
+	// Total amount of swap space on this system
+	Total float64 `json:"total"`
+
+	// Swap space currently in use
+	Used float64 `json:"used"`
+
+	// Swap space still available
+	Free float64 `json:"free"`
+
+	// Percentage of swap space in use
+	UsedPercent float64 `json:"usedPercent"`
 }
+
+// LoadAverage is the system load average. Windows has none, so there it is
+// approximated from the processor queue length.
 type LoadAverage struct {
 	Load1  float64 `json:"load1"`  // Average over the last 1 minute
 	Load5  float64 `json:"load5"`  // Average over the last 5 minutes
 	Load15 float64 `json:"load15"` // Average over the last 15 minutes
+
+	// Load averages divided by the number of logical cores, so 1.0 means
+	// saturated whatever the size of the machine
+	Load1PerCore  float64 `json:"load1PerCore"`
+	Load5PerCore  float64 `json:"load5PerCore"`
+	Load15PerCore float64 `json:"load15PerCore"`
+}
+type CPU struct {
+	// Percentage of CPU time used across all cores since the previous snapshot
+	UsedPercent float64 `json:"usedPercent"`
+
+	// Percentage of CPU time used by each logical core since the previous snapshot
+	PerCore []float64 `json:"perCore"`
+
+	// Where CPU time went across all cores since the previous snapshot
+	Times CPUTimes `json:"times"`
+
+	// Set on the first snapshot when there was no earlier reading to compare
+	// with; the percentages are zero
+	WarmingUp bool `json:"warmingUp,omitempty"`
+}
+
+// CPUTimes splits CPU time into percentages of the total. Iowait is idle
+// time with disk I/O outstanding, and Steal is time a virtual machine was
+// ready to run but the hypervisor gave the CPU to another guest; both are
+// always zero where the platform does not report them. The fields leave out
+// interrupt handling, so they can add up to a little under 100.
+type CPUTimes struct {
+	User   float64 `json:"user"`
+	System float64 `json:"system"`
+	Idle   float64 `json:"idle"`
+	Iowait float64 `json:"iowait"`
+	Steal  float64 `json:"steal"`
+	Nice   float64 `json:"nice"`
 }
+
+// Disk is the combined space of several partitions
 type Disk struct {
-	Total       uint64  `json:"total"`
-	Used        uint64  `json:"used"`
-	Free        uint64  `json:"free"`
+	Total       float64 `json:"total"`
+	Used        float64 `json:"used"`
+	Free        float64 `json:"free"`
 	UsedPercent float64 `json:"usedPercent"`
 }
 
@@ -335,28 +2097,227 @@ type DiskPartition struct {
 	Device      string  `json:"device"`
 	Mountpoint  string  `json:"mountpoint"`
 	Fstype      string  `json:"fstype"`
-	Total       uint64  `json:"total"`
-	Used        uint64  `json:"used"`
-	Free        uint64  `json:"free"`
+	Total       float64 `json:"total"`
+	Used        float64 `json:"used"`
+	Free        float64 `json:"free"`
 	UsedPercent float64 `json:"usedPercent"`
+
+	// Inode usage; a filesystem full of small files can run out of inodes
+	// long before it runs out of space. Zero on filesystems without a fixed
+	// inode table, such as btrfs.
+	InodesTotal       uint64  `json:"inodesTotal"`
+	InodesUsed        uint64  `json:"inodesUsed"`
+	InodesFree        uint64  `json:"inodesFree"`
+	InodesUsedPercent float64 `json:"inodesUsedPercent"`
+}
+
+type DiskIOStat struct {
+	Name string `json:"name"`
+
+	// Cumulative counters since boot
+	ReadBytes  uint64 `json:"readBytes"`
+	WriteBytes uint64 `json:"writeBytes"`
+	ReadCount  uint64 `json:"readCount"`
+	WriteCount uint64 `json:"writeCount"`
+
+	// Milliseconds spent doing I/O
+	IoTime uint64 `json:"ioTime"`
+
+	// Per-second rates since the previous snapshot; zero in the first one
+	ReadBytesPerSec  float64 `json:"readBytesPerSec"`
+	WriteBytesPerSec float64 `json:"writeBytesPerSec"`
+	ReadCountPerSec  float64 `json:"readCountPerSec"`
+	WriteCountPerSec float64 `json:"writeCountPerSec"`
+
+	// Milliseconds of I/O per second; 1000 means the device was always busy
+	IoTimePerSec float64 `json:"ioTimePerSec"`
+}
+
+// Disks is the storage part of a snapshot, as returned by /api/disks
+type Disks struct {
+	Partitions []DiskPartition `json:"partitions"`
+	DiskIO     []DiskIOStat    `json:"diskIO"`
+}
+
+type NetInterface struct {
+	Name string `json:"name"`
+
+	// Cumulative counters since the interface came up
+	BytesSent   uint64 `json:"bytesSent"`
+	BytesRecv   uint64 `json:"bytesRecv"`
+	PacketsSent uint64 `json:"packetsSent"`
+	PacketsRecv uint64 `json:"packetsRecv"`
+
+	// Per-second rates since the previous snapshot; zero in the first one
+	BytesSentPerSec   float64 `json:"bytesSentPerSec"`
+	BytesRecvPerSec   float64 `json:"bytesRecvPerSec"`
+	PacketsSentPerSec float64 `json:"packetsSentPerSec"`
+	PacketsRecvPerSec float64 `json:"packetsRecvPerSec"`
+
+	loopback bool
+}
+
+// NetTotal is the combined throughput of every non-loopback interface
+type NetTotal struct {
+	BytesSentPerSec   float64 `json:"bytesSentPerSec"`
+	BytesRecvPerSec   float64 `json:"bytesRecvPerSec"`
+	PacketsSentPerSec float64 `json:"packetsSentPerSec"`
+	PacketsRecvPerSec float64 `json:"packetsRecvPerSec"`
+}
+
+type FanStat struct {
+	SensorKey string `json:"sensorKey"`
+
+	// Current speed in revolutions per minute; 0 for a stopped fan
+	RPM int64 `json:"rpm"`
+}
+
+type TemperatureStat struct {
+	SensorKey string `json:"sensorKey"`
+
+	// Current reading in degrees Celsius
+	Temperature float64 `json:"temperature"`
+
+	// Thresholds reported by the sensor, or zero when it has none
+	High     float64 `json:"high"`
+	Critical float64 `json:"critical"`
 }
 
 type ProcessInfo struct {
-	PID           int32   `json:"pid"`
-	Name          string  `json:"name"`
-	CPUPercent    float64 `json:"cpuPercent"`
-	MemoryMB      float64 `json:"memoryMB"`
-	MemoryPercent float32 `json:"memoryPercent"`
+	PID  int32  `json:"pid"`
+	PPID int32  `json:"ppid"` // Parent PID, or 0 when unknown
+	Name string `json:"name"`
+
+	// CPU usage where 100 is one fully busy core, so a multithreaded process
+	// can exceed 100. With ?cpu-mode=total it is divided by the core count
+	// and 100 means every core is busy.
+	CPUPercent float64 `json:"cpuPercent"`
+
+	// Set when there is no earlier reading of the process to compare with,
+	// as on the first snapshot or for a process that just started.
+	// CPUPercent is then the average over its whole lifetime.
+	CPUPreliminary bool `json:"cpuPreliminary,omitempty"`
+
+	// User and system CPU seconds used since the process started. Unlike
+	// CPUPercent it singles out long-running heavy users even while they
+	// are idle.
+	CPUTimeSeconds float64 `json:"cpuTimeSeconds"`
+
+	// Resident set size, the memory actually in RAM, and virtual size, the
+	// whole address space including mapped files and reservations never
+	// touched
+	MemoryMB  float64 `json:"memoryMB"`
+	VirtualMB float64 `json:"virtualMB"`
+
+	MemoryPercent float32 `json:"memoryPercent"` // RSS share of total memory
 	Status        string  `json:"status"`
 	Username      string  `json:"username"`
-	Cmdline       string  `json:"cmdline"`
+
+	// Set for a process that has exited but not been reaped by its parent
+	Zombie bool `json:"zombie,omitempty"`
+
+	// Command line, cut to -cmdline-max characters in snapshots. The full
+	// value is at /api/process/{pid}/cmdline when CmdlineTruncated is set.
+	Cmdline          string `json:"cmdline"`
+	CmdlineTruncated bool   `json:"cmdlineTruncated,omitempty"`
+
+	// Open file descriptors and threads, or -1 when they cannot be read
+	NumFDs     int32 `json:"numFDs"`
+	NumThreads int32 `json:"numThreads"`
+
+	// Unix milliseconds when the process started, or 0 when unknown
+	CreateTime int64 `json:"createTime"`
+
+	// Whole seconds the process has been running, or 0 when the start time
+	// is unknown. A service that keeps restarting shows a small age.
+	AgeSeconds int64 `json:"ageSeconds"`
+
+	// Bytes read from and written to storage since the process started,
+	// and per-second rates since the previous snapshot. Zero when the
+	// platform or permissions do not allow reading them.
+	ReadBytes        uint64  `json:"readBytes"`
+	WriteBytes       uint64  `json:"writeBytes"`
+	ReadBytesPerSec  float64 `json:"readBytesPerSec"`
+	WriteBytesPerSec float64 `json:"writeBytesPerSec"`
 }
 
+// schemaVersion identifies the shape of Resources. Bump it whenever a field
+// is added, removed, renamed or changes meaning, so clients can tell which
+// fields to expect from the server they are talking to.
+const schemaVersion = 13
+
 type Resources struct {
-	Hostname    string          `json:"hostname"`
-	Uptime      uint64          `json:"uptime"`
-	Memory      Memory          `json:"memory"`
-	LoadAverage LoadAverage     `json:"load_average"`
-	Partitions  []DiskPartition `json:"partitions"`
-	Processes   []ProcessInfo   `json:"processes"`
+	SchemaVersion int `json:"schemaVersion"`
+
+	// When collection started, in RFC 3339 format, and how long it took.
+	// A duration close to the interval means collection is struggling to
+	// keep up.
+	Timestamp            time.Time `json:"timestamp"`
+	CollectionDurationMs int64     `json:"collectionDurationMs"`
+
+	Hostname    string   `json:"hostname"`
+	Uptime      uint64   `json:"uptime"`
+	UptimeHuman string   `json:"uptimeHuman"`
+	BootTime    uint64   `json:"bootTime"` // Unix seconds
+	Host        HostInfo `json:"host"`
+	CPUCount    int      `json:"cpuCount"` // Logical cores
+
+	// Unit of the sizes in Memory, Swap, Partitions and DiskTotal: bytes,
+	// MB or GB, as set by -mem-unit. Always bytes inside the server.
+	Unit string `json:"unit"`
+
+	Memory      Memory      `json:"memory"`
+	Swap        Swap        `json:"swap"`
+	LoadAverage LoadAverage `json:"loadAverage"`
+	CPU         CPU         `json:"cpu"`
+
+	// Clock speed in MHz from cpu.Info: one entry per logical core on
+	// Linux, often one per socket elsewhere. It is not always the live
+	// speed: Linux reports each core's maximum when cpufreq is available and
+	// the current speed from /proc/cpuinfo otherwise, and macOS and Windows
+	// report the nominal speed. Empty when the platform reports nothing.
+	CPUFrequency []float64 `json:"cpuFrequency"`
+
+	Partitions []DiskPartition `json:"partitions"`
+	DiskTotal  Disk            `json:"diskTotal"` // Sum of Partitions, one per device
+	DiskIO     []DiskIOStat    `json:"diskIO"`
+	Network    []NetInterface  `json:"network"`
+
+	// Per-second rates summed over the non-loopback interfaces in Network;
+	// zero in the first snapshot
+	NetworkTotal NetTotal `json:"networkTotal"`
+
+	// Number of TCP connections in each state, e.g. {"ESTABLISHED": 12}
+	NetworkConnections map[string]int `json:"networkConnections"`
+
+	Sensors   []TemperatureStat `json:"sensors"`
+	Fans      []FanStat         `json:"fans"`               // Linux only
+	GPUs      []GPUStat         `json:"gpus"`               // Empty without the NVIDIA driver
+	Processes []ProcessInfo     `json:"processes,omitzero"` // Left out entirely with ?compact=true
+
+	// Zombie processes among all of them, not only those in Processes. A
+	// count that keeps growing means a parent is not reaping its children.
+	ZombieCount int `json:"zombieCount"`
+
+	// Set when processes were dropped to keep the message under
+	// -max-payload-bytes
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Set when collection ran out of time and some partitions or processes
+	// are missing
+	Partial bool `json:"partial,omitempty"`
+
+	// Set when there were more processes than -max-process-scan, so only
+	// those with the highest PIDs were read
+	ProcessesCapped bool `json:"processesCapped,omitempty"`
+
+	// When Processes was read; under -process-interval several snapshots
+	// in a row carry the same list
+	processesAt time.Time
+
+	// Subsystems that could not be collected, keyed by section, with the
+	// reason. Their sections are zeroed; omitted when everything succeeded.
+	// A partition whose usage could not be read is keyed
+	// "partitions:<mountpoint>" and left out of Partitions.
+	Errors map[string]string `json:"errors,omitempty"`
 }
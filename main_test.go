@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestListenAddr(t *testing.T) {
+	tests := []struct {
+		name string
+		host string
+		port int
+		want string
+	}{
+		{name: "all interfaces", host: "", port: 8080, want: ":8080"},
+		{name: "IPv4 loopback", host: "127.0.0.1", port: 8080, want: "127.0.0.1:8080"},
+		{name: "IPv6 loopback", host: "::1", port: 8080, want: "[::1]:8080"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := listenAddr(tt.host, tt.port)
+			if got != tt.want {
+				t.Errorf("listenAddr(%q, %d) = %q, want %q", tt.host, tt.port, got, tt.want)
+			}
+		})
+	}
+}
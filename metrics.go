@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// metricsHandler exposes a snapshot in the Prometheus text exposition format
+// (version 0.0.4) so it can be scraped without a WebSocket client.
+func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	shared, err := app.collector.current(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	rs := *shared
+	rs.Processes = app.selectProcesses(rs.Processes, processQuery{})
+
+	var buf bytes.Buffer
+	writeMetrics(&buf, rs)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// writeMetrics renders every gauge for a snapshot. Each family is written as
+// a single block so HELP and TYPE lines precede all of its samples.
+func writeMetrics(buf *bytes.Buffer, rs Resources) {
+	m := metricsWriter{buf: buf, hostname: rs.Hostname}
+
+	m.header("res_mon_host_info", "Platform of the host; the value is always 1.")
+	m.sample("res_mon_host_info", 1,
+		"os", rs.Host.OS,
+		"platform", rs.Host.Platform,
+		"platform_version", rs.Host.PlatformVersion,
+		"kernel_version", rs.Host.KernelVersion,
+		"kernel_arch", rs.Host.KernelArch,
+	)
+
+	m.gauge("res_mon_uptime_seconds", "Seconds since the host booted.", float64(rs.Uptime))
+
+	m.gauge("res_mon_memory_total_bytes", "Total amount of RAM.", float64(rs.Memory.Total))
+	m.gauge("res_mon_memory_used_bytes", "RAM used by programs.", float64(rs.Memory.Used))
+	m.gauge("res_mon_memory_available_bytes", "RAM available for programs to allocate.", float64(rs.Memory.Available))
+	m.gauge("res_mon_memory_used_percent", "Percentage of RAM used by programs.", rs.Memory.UsedPercent)
+
+	m.gauge("res_mon_swap_total_bytes", "Total amount of swap space.", float64(rs.Swap.Total))
+	m.gauge("res_mon_swap_used_bytes", "Swap space in use.", float64(rs.Swap.Used))
+
+	m.gauge("res_mon_load1", "Load average over the last minute.", rs.LoadAverage.Load1)
+	m.gauge("res_mon_load5", "Load average over the last 5 minutes.", rs.LoadAverage.Load5)
+	m.gauge("res_mon_load15", "Load average over the last 15 minutes.", rs.LoadAverage.Load15)
+
+	m.gauge("res_mon_cpu_used_percent", "Percentage of CPU time used across all cores.", rs.CPU.UsedPercent)
+
+	m.header("res_mon_disk_total_bytes", "Total size of the partition.")
+	for _, p := range rs.Partitions {
+		m.sample("res_mon_disk_total_bytes", float64(p.Total), "device", p.Device, "mountpoint", p.Mountpoint)
+	}
+	m.header("res_mon_disk_used_bytes", "Space used on the partition.")
+	for _, p := range rs.Partitions {
+		m.sample("res_mon_disk_used_bytes", float64(p.Used), "device", p.Device, "mountpoint", p.Mountpoint)
+	}
+	m.header("res_mon_disk_used_percent", "Percentage of the partition in use.")
+	for _, p := range rs.Partitions {
+		m.sample("res_mon_disk_used_percent", p.UsedPercent, "device", p.Device, "mountpoint", p.Mountpoint)
+	}
+
+	m.header("res_mon_process_cpu_percent", "CPU usage of the process.")
+	for _, p := range rs.Processes {
+		m.sample("res_mon_process_cpu_percent", p.CPUPercent, "pid", strconv.Itoa(int(p.PID)), "name", p.Name)
+	}
+	m.header("res_mon_process_memory_bytes", "Resident memory of the process.")
+	for _, p := range rs.Processes {
+		m.sample("res_mon_process_memory_bytes", p.MemoryMB*1024*1024, "pid", strconv.Itoa(int(p.PID)), "name", p.Name)
+	}
+}
+
+// metricsWriter adds the hostname label to every sample it writes
+type metricsWriter struct {
+	buf      *bytes.Buffer
+	hostname string
+}
+
+func (m metricsWriter) header(name, help string) {
+	fmt.Fprintf(m.buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+// gauge writes a family that has a single, hostname-only sample
+func (m metricsWriter) gauge(name, help string, value float64) {
+	m.header(name, help)
+	m.sample(name, value)
+}
+
+// sample writes one line; labels are given as alternating name/value pairs
+func (m metricsWriter) sample(name string, value float64, labels ...string) {
+	m.buf.WriteString(name)
+	m.buf.WriteString(`{hostname="`)
+	m.buf.WriteString(escapeLabelValue(m.hostname))
+	m.buf.WriteByte('"')
+	for i := 0; i+1 < len(labels); i += 2 {
+		fmt.Fprintf(m.buf, `,%s="%s"`, labels[i], escapeLabelValue(labels[i+1]))
+	}
+	m.buf.WriteString("} ")
+	m.buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	m.buf.WriteByte('\n')
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
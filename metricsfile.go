@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// metricsFile appends snapshots to a file as newline-delimited JSON. Once
+// the file would grow past maxBytes it is renamed with a .1 suffix,
+// replacing the previous one, and a fresh file is started, so the two
+// together never take much more than twice maxBytes.
+type metricsFile struct {
+	path     string
+	maxBytes int64
+
+	file *os.File
+	size int64
+}
+
+func openMetricsFile(path string, maxBytes int64) (*metricsFile, error) {
+	m := &metricsFile{path: path, maxBytes: maxBytes}
+	err := m.open()
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *metricsFile) open() error {
+	f, err := os.OpenFile(m.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	m.file, m.size = f, info.Size()
+	return nil
+}
+
+// write appends one line, rotating first when it would not fit. A failed
+// rotation is reported, but the line is still written to the current file
+// so no snapshot is lost.
+func (m *metricsFile) write(line []byte) error {
+	var rotateErr error
+	if m.maxBytes > 0 && m.size > 0 && m.size+int64(len(line)) > m.maxBytes {
+		rotateErr = m.rotate()
+	}
+
+	n, err := m.file.Write(line)
+	m.size += int64(n)
+	return errors.Join(rotateErr, err)
+}
+
+// rotate renames the file to .1 and starts a new one. The old file is only
+// closed once the new one is open, so when either step fails writes carry
+// on into the file that is still open.
+func (m *metricsFile) rotate() error {
+	err := os.Rename(m.path, m.path+".1")
+	if err != nil {
+		return err
+	}
+
+	old := m.file
+	err = m.open()
+	if err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+func (m *metricsFile) Close() error {
+	return m.file.Close()
+}
+
+// runMetricsFile appends every snapshot from the collector to -metrics-file
+// until the server shuts down. Each line holds what a dashboard with no
+// query parameters receives.
+func (app *application) runMetricsFile() {
+	if app.config.metricsFile == "" {
+		return
+	}
+
+	m, err := openMetricsFile(app.config.metricsFile, int64(app.config.metricsFileMaxMB)*1024*1024)
+	if err != nil {
+		app.logger.Error("opening metrics file", "path", app.config.metricsFile, "error", err)
+		return
+	}
+	defer m.Close()
+
+	updates, _ := app.collector.subscribe()
+	defer app.collector.unsubscribe(updates)
+
+	for {
+		select {
+		case <-app.shutdown:
+			return
+		case rs := <-updates:
+			view := app.clientView(rs, processQuery{})
+			js, err := app.marshalJSON(view)
+			if err != nil {
+				app.logger.Error("encoding snapshot", "error", err)
+				continue
+			}
+
+			err = m.write(append(js, '\n'))
+			if err != nil {
+				app.logger.Error("writing metrics file", "path", app.config.metricsFile, "error", err)
+			}
+		}
+	}
+}
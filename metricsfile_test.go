@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMetricsFileRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.jsonl")
+	line := []byte(`{"cpu":1}` + "\n")
+
+	m, err := openMetricsFile(path, int64(2*len(line)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	for i := 0; i < 3; i++ {
+		err := m.write(line)
+		if err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+
+	for name, want := range map[string]int{path: 1, path + ".1": 2} {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Count(string(b), "\n"); got != want {
+			t.Errorf("%s has %d lines, want %d", name, got, want)
+		}
+	}
+}
+
+func TestMetricsFileFailedRotationKeepsWriting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.jsonl")
+	line := []byte(`{"cpu":1}` + "\n")
+
+	m, err := openMetricsFile(path, int64(len(line)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	err = m.write(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory in the way makes the rename fail
+	err = os.Mkdir(path+".1", 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(path+".1", "keep"), nil, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.write(line); err == nil {
+		t.Error("write succeeded although rotation failed")
+	}
+	if err := m.write(line); err == nil {
+		t.Error("second write succeeded although rotation failed")
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(b), "\n"); got != 3 {
+		t.Errorf("%s has %d lines after failed rotations, want 3", path, got)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// marshalMsgpack encodes v as MessagePack with the same fields, names and
+// order as marshalJSONCase(v, jsonCase): struct tags, omitempty, omitzero
+// and embedded structs are handled as encoding/json handles them, and the
+// names go through snakeCase when jsonCase is snake.
+//
+// Whole numbers become MessagePack integers and the rest 64-bit floats, as
+// a JSON decoder would read them; timestamps stay RFC 3339 strings.
+func marshalMsgpack(v any, jsonCase string) ([]byte, error) {
+	name := func(s string) string { return s }
+	if jsonCase == "snake" {
+		name = snakeCase
+	}
+
+	var buf bytes.Buffer
+	err := encodeMsgpack(&buf, reflect.ValueOf(v), name)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var jsonNumberType = reflect.TypeFor[json.Number]()
+
+// encodeMsgpack writes v to buf, naming struct fields with name
+func encodeMsgpack(buf *bytes.Buffer, v reflect.Value, name func(string) string) error {
+	if !v.IsValid() {
+		buf.WriteByte(0xc0)
+		return nil
+	}
+
+	if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+		buf.WriteByte(0xc0)
+		return nil
+	}
+
+	// Types that encode themselves, such as time.Time, come out as they
+	// would in JSON: the text form as a string, anything else decoded from
+	// its JSON encoding
+	if v.Type().Implements(textMarshalerType) {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+		msgpackString(buf, string(text))
+		return nil
+	}
+	if v.Type().Implements(jsonMarshalerType) {
+		js, err := json.Marshal(v.Interface())
+		if err != nil {
+			return err
+		}
+		dec := json.NewDecoder(bytes.NewReader(js))
+		dec.UseNumber()
+		var decoded any
+		err = dec.Decode(&decoded)
+		if err != nil {
+			return err
+		}
+		return encodeMsgpack(buf, reflect.ValueOf(decoded), name)
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return encodeMsgpack(buf, v.Elem(), name)
+
+	case reflect.Struct:
+		// MessagePack puts the element count before the elements, so they
+		// are encoded separately first
+		var body bytes.Buffer
+		n, err := encodeMsgpackFields(&body, v, name)
+		if err != nil {
+			return err
+		}
+		msgpackHeader(buf, n, 0x80, 16, 0xde, 0xdf)
+		buf.Write(body.Bytes())
+		return nil
+
+	case reflect.Map:
+		if v.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+
+		// Keys become strings, sorted, as json.Marshal writes them
+		type entry struct {
+			key   string
+			value reflect.Value
+		}
+		entries := make([]entry, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := msgpackMapKey(iter.Key())
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry{key, iter.Value()})
+		}
+		slices.SortFunc(entries, func(a, b entry) int {
+			return strings.Compare(a.key, b.key)
+		})
+
+		msgpackHeader(buf, len(entries), 0x80, 16, 0xde, 0xdf)
+		for _, e := range entries {
+			msgpackString(buf, e.key)
+			err := encodeMsgpack(buf, e.value, name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		// encoding/json writes byte slices as base64
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			msgpackString(buf, base64.StdEncoding.EncodeToString(v.Bytes()))
+			return nil
+		}
+
+		msgpackHeader(buf, v.Len(), 0x90, 16, 0xdc, 0xdd)
+		for i := range v.Len() {
+			err := encodeMsgpack(buf, v.Index(i), name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.String:
+		if v.Type() == jsonNumberType {
+			return msgpackNumber(buf, json.Number(v.String()))
+		}
+		msgpackString(buf, v.String())
+		return nil
+
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		msgpackInt(buf, v.Int())
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		msgpackUint(buf, v.Uint())
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Errorf("unsupported value %v", f)
+		}
+		// A float32 reads back as the shortest decimal that identifies it,
+		// as in JSON, rather than as its exact binary value
+		if v.Kind() == reflect.Float32 {
+			f, _ = strconv.ParseFloat(strconv.FormatFloat(f, 'g', -1, 32), 64)
+		}
+		msgpackFloat(buf, f)
+		return nil
+	}
+
+	return fmt.Errorf("unsupported type %s", v.Type())
+}
+
+// encodeMsgpackFields writes the fields of a struct as key and value pairs,
+// flattening embedded structs into it as json.Marshal does, and returns
+// how many it wrote
+func encodeMsgpackFields(buf *bytes.Buffer, v reflect.Value, name func(string) string) (int, error) {
+	n := 0
+	t := v.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+
+		fieldName, opts, _ := strings.Cut(tag, ",")
+		value := v.Field(i)
+
+		if field.Anonymous && fieldName == "" && field.Type.Kind() == reflect.Struct {
+			embedded, err := encodeMsgpackFields(buf, value, name)
+			if err != nil {
+				return 0, err
+			}
+			n += embedded
+			continue
+		}
+
+		if hasOption(opts, "omitzero") && value.IsZero() {
+			continue
+		}
+		if hasOption(opts, "omitempty") && isEmptyValue(value) {
+			continue
+		}
+
+		if fieldName == "" {
+			fieldName = field.Name
+		}
+
+		msgpackString(buf, name(fieldName))
+		err := encodeMsgpack(buf, value, name)
+		if err != nil {
+			return 0, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// msgpackMapKey returns the string a map key becomes, as in encoding/json
+func msgpackMapKey(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		text, err := tm.MarshalText()
+		return string(text), err
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("unsupported map key type %s", k.Type())
+}
+
+// msgpackHeader writes the length prefix of a map, array or string: fixed
+// when n is below fixMax, otherwise the 16- or 32-bit form
+func msgpackHeader(buf *bytes.Buffer, n int, fixed byte, fixMax int, code16, code32 byte) {
+	switch {
+	case n < fixMax:
+		buf.WriteByte(fixed | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(code16)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		buf.WriteByte(code32)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+}
+
+func msgpackString(buf *bytes.Buffer, s string) {
+	if len(s) >= 32 && len(s) <= math.MaxUint8 {
+		buf.WriteByte(0xd9)
+		buf.WriteByte(byte(len(s)))
+	} else {
+		msgpackHeader(buf, len(s), 0xa0, 32, 0xda, 0xdb)
+	}
+	buf.WriteString(s)
+}
+
+// msgpackInt writes i in the smallest integer form that holds it
+func msgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= math.MaxInt8:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buf.WriteByte(0xd1)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(int16(i))))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf.WriteByte(0xd2)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(int32(i))))
+	default:
+		buf.WriteByte(0xd3)
+		buf.Write(binary.BigEndian.AppendUint64(nil, uint64(i)))
+	}
+}
+
+// msgpackUint writes u as msgpackInt does, or as a uint64 when it is too
+// big for an int64, as byte counters can be
+func msgpackUint(buf *bytes.Buffer, u uint64) {
+	if u <= math.MaxInt64 {
+		msgpackInt(buf, int64(u))
+		return
+	}
+	buf.WriteByte(0xcf)
+	buf.Write(binary.BigEndian.AppendUint64(nil, u))
+}
+
+// msgpackFloat writes f as an integer when it is a whole number that fits
+// one, otherwise as a float64
+func msgpackFloat(buf *bytes.Buffer, f float64) {
+	if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+		msgpackInt(buf, int64(f))
+		return
+	}
+	buf.WriteByte(0xcb)
+	buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+}
+
+// msgpackNumber writes a json.Number as the integer or float it holds
+func msgpackNumber(buf *bytes.Buffer, n json.Number) error {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		msgpackInt(buf, i)
+		return nil
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		msgpackUint(buf, u)
+		return nil
+	}
+
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return err
+	}
+	buf.WriteByte(0xcb)
+	buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+	return nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarshalMsgpack(t *testing.T) {
+	type inner struct {
+		A int    `json:"a"`
+		B string `json:"b,omitempty"`
+	}
+
+	tests := []struct {
+		name string
+		v    any
+		want string // hex
+	}{
+		{name: "nil", v: nil, want: "c0"},
+		{name: "true", v: true, want: "c3"},
+		{name: "false", v: false, want: "c2"},
+		{name: "positive fixint", v: 5, want: "05"},
+		{name: "negative fixint", v: -5, want: "fb"},
+		{name: "int8", v: -100, want: "d09c"},
+		{name: "int16", v: 1000, want: "d103e8"},
+		{name: "int32", v: int64(100000), want: "d2000186a0"},
+		{name: "uint64", v: uint64(math.MaxUint64), want: "cfffffffffffffffff"},
+		{name: "float", v: 1.5, want: "cb3ff8000000000000"},
+		{name: "whole float", v: 2.0, want: "02"},
+		{name: "float32", v: float32(0.15), want: "cb3fc3333333333333"},
+		{name: "string", v: "hi", want: "a26869"},
+		{name: "array", v: []int{1, 2}, want: "920102"},
+		{name: "nil slice", v: []int(nil), want: "c0"},
+		{name: "map sorted by key", v: map[string]int{"b": 2, "a": 1}, want: "82a16101a16202"},
+		{name: "struct omits empty", v: inner{A: 1}, want: "81a16101"},
+		{name: "struct", v: inner{A: 1, B: "x"}, want: "82a16101a162a178"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := marshalMsgpack(tt.v, "camel")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hex.EncodeToString(got) != tt.want {
+				t.Errorf("marshalMsgpack(%#v) = %x, want %s", tt.v, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMarshalMsgpackMatchesJSON checks that a snapshot decodes to the same
+// document from MessagePack as from JSON, in both field cases
+func TestMarshalMsgpackMatchesJSON(t *testing.T) {
+	rs := &Resources{
+		SchemaVersion: schemaVersion,
+		Timestamp:     time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC),
+		Unit:          "bytes",
+		Hostname:      strings.Repeat("h", 40),
+		Memory:        Memory{Total: 16 << 30, UsedPercent: 42.5},
+		CPU:           CPU{UsedPercent: 12.25, PerCore: []float64{10, 14.5}},
+		DiskIO:        []DiskIOStat{{Name: "sda", ReadBytes: math.MaxUint64}},
+		NetworkConnections: map[string]int{
+			"LISTEN":      3,
+			"ESTABLISHED": 12,
+		},
+		Processes: []ProcessInfo{
+			{PID: 1, Name: "init", CPUPercent: -0.5, MemoryPercent: 0.15, Zombie: true},
+		},
+	}
+
+	for _, jsonCase := range []string{"camel", "snake"} {
+		t.Run(jsonCase, func(t *testing.T) {
+			js, err := marshalJSONCase(rs, jsonCase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dec := json.NewDecoder(bytes.NewReader(js))
+			dec.UseNumber()
+			var want any
+			err = dec.Decode(&want)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mp, err := marshalMsgpack(rs, jsonCase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, rest, err := decodeMsgpack(mp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rest) > 0 {
+				t.Fatalf("%d bytes left after the document", len(rest))
+			}
+
+			if !reflect.DeepEqual(got, normalizeNumbers(want)) {
+				t.Errorf("MessagePack decodes to\n%v\nwant\n%v", got, normalizeNumbers(want))
+			}
+		})
+	}
+}
+
+// normalizeNumbers turns the json.Numbers in a decoded document into the
+// float64s decodeMsgpack returns
+func normalizeNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, e := range v {
+			v[k] = normalizeNumbers(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = normalizeNumbers(e)
+		}
+	}
+	return v
+}
+
+// decodeMsgpack decodes the forms marshalMsgpack writes, returning numbers
+// as float64 like a JSON decoder, and the bytes after the value
+func decodeMsgpack(b []byte) (any, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("unexpected end of input")
+	}
+	c, b := b[0], b[1:]
+
+	need := func(n int) ([]byte, error) {
+		if len(b) < n {
+			return nil, fmt.Errorf("unexpected end of input")
+		}
+		return b[:n], nil
+	}
+	length := func(size int) (int, error) {
+		p, err := need(size)
+		if err != nil {
+			return 0, err
+		}
+		b = b[size:]
+		switch size {
+		case 1:
+			return int(p[0]), nil
+		case 2:
+			return int(binary.BigEndian.Uint16(p)), nil
+		default:
+			return int(binary.BigEndian.Uint32(p)), nil
+		}
+	}
+
+	var n int
+	var err error
+	switch {
+	case c <= 0x7f:
+		return float64(c), b, nil
+	case c >= 0xe0:
+		return float64(int8(c)), b, nil
+	case c == 0xc0:
+		return nil, b, nil
+	case c == 0xc2:
+		return false, b, nil
+	case c == 0xc3:
+		return true, b, nil
+	case c == 0xd0, c == 0xd1, c == 0xd2, c == 0xd3, c == 0xcf, c == 0xcb:
+		size := map[byte]int{0xd0: 1, 0xd1: 2, 0xd2: 4, 0xd3: 8, 0xcf: 8, 0xcb: 8}[c]
+		p, err := need(size)
+		if err != nil {
+			return nil, nil, err
+		}
+		b = b[size:]
+		switch c {
+		case 0xd0:
+			return float64(int8(p[0])), b, nil
+		case 0xd1:
+			return float64(int16(binary.BigEndian.Uint16(p))), b, nil
+		case 0xd2:
+			return float64(int32(binary.BigEndian.Uint32(p))), b, nil
+		case 0xd3:
+			return float64(int64(binary.BigEndian.Uint64(p))), b, nil
+		case 0xcf:
+			return float64(binary.BigEndian.Uint64(p)), b, nil
+		default:
+			return math.Float64frombits(binary.BigEndian.Uint64(p)), b, nil
+		}
+	case c&0xe0 == 0xa0, c == 0xd9, c == 0xda, c == 0xdb:
+		switch c {
+		case 0xd9:
+			n, err = length(1)
+		case 0xda:
+			n, err = length(2)
+		case 0xdb:
+			n, err = length(4)
+		default:
+			n = int(c & 0x1f)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		p, err := need(n)
+		if err != nil {
+			return nil, nil, err
+		}
+		return string(p), b[n:], nil
+	case c&0xf0 == 0x90, c == 0xdc, c == 0xdd:
+		switch c {
+		case 0xdc:
+			n, err = length(2)
+		case 0xdd:
+			n, err = length(4)
+		default:
+			n = int(c & 0x0f)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i], b, err = decodeMsgpack(b)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		return list, b, nil
+	case c&0xf0 == 0x80, c == 0xde, c == 0xdf:
+		switch c {
+		case 0xde:
+			n, err = length(2)
+		case 0xdf:
+			n, err = length(4)
+		default:
+			n = int(c & 0x0f)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		m := make(map[string]any, n)
+		for range n {
+			var key, value any
+			key, b, err = decodeMsgpack(b)
+			if err != nil {
+				return nil, nil, err
+			}
+			s, ok := key.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("map key %v is not a string", key)
+			}
+			value, b, err = decodeMsgpack(b)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[s] = value
+		}
+		return m, b, nil
+	}
+	return nil, nil, fmt.Errorf("unexpected type byte %#x", c)
+}
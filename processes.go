@@ -0,0 +1,621 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shirou/gopsutil/v4/cpu"
+	"github.com/shirou/gopsutil/v4/mem"
+	psnet "github.com/shirou/gopsutil/v4/net"
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+// processQuery narrows and orders the process list sent to a single client
+type processQuery struct {
+	// Case-insensitive substring of the process name
+	name string
+
+	// Case-insensitive substring of the process name or command line
+	search string
+
+	// Exact username
+	user string
+
+	// Statuses, as reported in ProcessInfo.Status, to keep; nil keeps all
+	statuses map[string]bool
+
+	// Key from processSortKeys, and whether to sort in descending order
+	sortBy string
+	desc   bool
+
+	// Report CPU usage as a share of the whole machine instead of one core
+	cpuTotal bool
+
+	// Leave the process list out altogether
+	compact bool
+
+	// Most processes to return; 0 means -top
+	limit int
+}
+
+// processSortKeys maps each ?sort= value to an ascending comparison
+var processSortKeys = map[string]func(a, b ProcessInfo) bool{
+	"cpu":  func(a, b ProcessInfo) bool { return a.CPUPercent < b.CPUPercent },
+	"mem":  func(a, b ProcessInfo) bool { return a.MemoryMB < b.MemoryMB },
+	"pid":  func(a, b ProcessInfo) bool { return a.PID < b.PID },
+	"name": func(a, b ProcessInfo) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) },
+}
+
+// processStatusCodes maps the ps(1) state letters accepted by ?status= to
+// the status names gopsutil reports
+var processStatusCodes = map[string]string{
+	"R": process.Running,
+	"S": process.Sleep,
+	"D": process.Blocked,
+	"I": process.Idle,
+	"T": process.Stop,
+	"Z": process.Zombie,
+	"W": process.Wait,
+	"L": process.Lock,
+}
+
+// parseProcessQuery reads the ?name=, ?user= and ?status= filters and the
+// ?sort= and ?order= options from a request. Without them processes are
+// sorted by CPU, busiest first; cpu and mem default to descending order, pid
+// and name to ascending. ?cpu-mode=total rescales CPU usage, see scaleCPU, and
+// ?compact=true drops the process list.
+func parseProcessQuery(v url.Values) (processQuery, error) {
+	q := processQuery{
+		name:   strings.ToLower(v.Get("name")),
+		user:   v.Get("user"),
+		sortBy: "cpu",
+	}
+
+	if sortBy := v.Get("sort"); sortBy != "" {
+		if _, ok := processSortKeys[sortBy]; !ok {
+			return processQuery{}, fmt.Errorf("invalid sort %q: must be one of cpu, mem, pid, name", sortBy)
+		}
+		q.sortBy = sortBy
+	}
+	q.desc = q.sortBy == "cpu" || q.sortBy == "mem"
+
+	switch order := v.Get("order"); order {
+	case "":
+	case "asc":
+		q.desc = false
+	case "desc":
+		q.desc = true
+	default:
+		return processQuery{}, fmt.Errorf("invalid order %q: must be asc or desc", order)
+	}
+
+	if codes := v.Get("status"); codes != "" {
+		q.statuses = make(map[string]bool)
+		for code := range strings.SplitSeq(codes, ",") {
+			status, ok := processStatusCodes[strings.ToUpper(strings.TrimSpace(code))]
+			if !ok {
+				return processQuery{}, fmt.Errorf("invalid status %q: must be a comma-separated list of R, S, D, I, T, Z, W, L", code)
+			}
+			q.statuses[status] = true
+		}
+	}
+
+	if c := v.Get("compact"); c != "" {
+		compact, err := strconv.ParseBool(c)
+		if err != nil {
+			return processQuery{}, fmt.Errorf("invalid compact %q: must be true or false", c)
+		}
+		q.compact = compact
+	}
+
+	switch mode := v.Get("cpu-mode"); mode {
+	case "", "core":
+	case "total":
+		q.cpuTotal = true
+	default:
+		return processQuery{}, fmt.Errorf("invalid cpu-mode %q: must be core or total", mode)
+	}
+
+	return q, nil
+}
+
+func (q processQuery) match(p ProcessInfo) bool {
+	if q.name != "" && !strings.Contains(strings.ToLower(p.Name), q.name) {
+		return false
+	}
+	if q.search != "" && !strings.Contains(strings.ToLower(p.Name), q.search) &&
+		!strings.Contains(strings.ToLower(p.Cmdline), q.search) {
+		return false
+	}
+	if q.user != "" && p.Username != q.user {
+		return false
+	}
+	if q.statuses != nil && !q.statuses[p.Status] {
+		return false
+	}
+	return true
+}
+
+// truncateCmdlines cuts command lines longer than limit characters, ending
+// them with an ellipsis. Java and Node command lines in particular can run
+// to kilobytes and would otherwise dominate every snapshot.
+func truncateCmdlines(processes []ProcessInfo, limit int) {
+	if limit <= 0 {
+		return
+	}
+	for i := range processes {
+		p := &processes[i]
+		if utf8.RuneCountInString(p.Cmdline) <= limit {
+			continue
+		}
+		p.Cmdline = string([]rune(p.Cmdline)[:limit]) + "…"
+		p.CmdlineTruncated = true
+	}
+}
+
+// clientView copies a shared snapshot, converts its sizes to -mem-unit and
+// narrows its process list down to what one client asked for. In compact mode the list is nil, so it is left
+// out of the JSON rather than sent as an empty array.
+func (app *application) clientView(shared *Resources, q processQuery) Resources {
+	rs := *shared
+	convertSizes(&rs, app.config.memUnit, app.config.round)
+	if q.compact {
+		rs.Processes = nil
+		return rs
+	}
+
+	rs.Processes = app.selectProcesses(rs.Processes, q)
+	q.scaleCPU(rs.Processes, rs.CPUCount, app.config.round)
+	return rs
+}
+
+// hideProcesses removes the processes whose name matches -process-hide, and
+// this server itself with -hide-self. It filters in place and returns the
+// shortened slice.
+func (app *application) hideProcesses(processes []ProcessInfo) []ProcessInfo {
+	if len(app.config.processHide) == 0 && !app.config.hideSelf {
+		return processes
+	}
+
+	self := int32(os.Getpid())
+	return slices.DeleteFunc(processes, func(p ProcessInfo) bool {
+		if app.config.hideSelf && p.PID == self {
+			return true
+		}
+
+		return matchAny(app.config.processHide, strings.ToLower(p.Name))
+	})
+}
+
+// selectProcesses returns the processes matching every filter in q, in the
+// requested order, limited to the first -top or q.limit. A zero
+// processQuery sorts by CPU, busiest first.
+func (app *application) selectProcesses(processes []ProcessInfo, q processQuery) []ProcessInfo {
+	selected := make([]ProcessInfo, 0, len(processes))
+	for _, p := range processes {
+		if q.match(p) {
+			selected = append(selected, p)
+		}
+	}
+
+	less, ok := processSortKeys[q.sortBy]
+	if !ok {
+		less, q.desc = processSortKeys["cpu"], true
+	}
+
+	// Ties fall back to memory, largest first, then PID, so processes with
+	// equal values keep their places from one snapshot to the next instead
+	// of swapping around in the dashboard
+	sort.SliceStable(selected, func(i, j int) bool {
+		a, b := selected[i], selected[j]
+		if q.desc {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+
+		a, b = selected[i], selected[j]
+		if a.MemoryMB != b.MemoryMB {
+			return a.MemoryMB > b.MemoryMB
+		}
+		return a.PID < b.PID
+	})
+
+	limit := app.config.topN
+	if q.limit > 0 {
+		limit = q.limit
+	}
+	if limit > 0 && len(selected) > limit {
+		selected = selected[:limit]
+	}
+	return selected
+}
+
+// scaleCPU divides each CPUPercent by the number of logical cores when the
+// client asked for ?cpu-mode=total, so 100% means the whole machine is busy
+// rather than a single core. It modifies processes in place, so it must only
+// be given a slice returned by selectProcesses. The results are rounded
+// again to places, as for -round.
+func (q processQuery) scaleCPU(processes []ProcessInfo, cpuCount int, places int) {
+	if !q.cpuTotal || cpuCount < 1 {
+		return
+	}
+	for i := range processes {
+		processes[i].CPUPercent /= float64(cpuCount)
+		if places >= 0 {
+			processes[i].CPUPercent = roundTo(processes[i].CPUPercent, places)
+		}
+	}
+}
+
+// killProcessHandler sends SIGTERM to a process, or SIGKILL with ?signal=KILL.
+// It is only routed when Basic Auth is enabled.
+func (app *application) killProcessHandler(w http.ResponseWriter, r *http.Request) {
+	// Browsers resend cached Basic Auth credentials, and a cross-site form
+	// POST needs no preflight, so any page an admin visits could otherwise
+	// kill processes. Origins are held to the same rule as WebSockets.
+	crossSite := r.Header.Get("Origin") == "" && r.Header.Get("Sec-Fetch-Site") == "cross-site"
+	if crossSite || !app.checkOrigin(r) {
+		http.Error(w, "cross-origin request refused", http.StatusForbidden)
+		return
+	}
+
+	signal := strings.ToUpper(r.URL.Query().Get("signal"))
+	if signal == "" {
+		signal = "TERM"
+	}
+	if signal != "TERM" && signal != "KILL" {
+		http.Error(w, "signal must be TERM or KILL", http.StatusBadRequest)
+		return
+	}
+
+	p, ok := findProcess(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	if signal == "KILL" {
+		err = p.Kill()
+	} else {
+		err = p.Terminate()
+	}
+	if err != nil {
+		switch {
+		// With pidfd, a process that exited since the lookup reports
+		// os.ErrProcessDone rather than ESRCH
+		case errors.Is(err, syscall.ESRCH), errors.Is(err, os.ErrProcessDone):
+			http.Error(w, "process not found", http.StatusNotFound)
+		case errors.Is(err, os.ErrPermission):
+			http.Error(w, "permission denied", http.StatusForbidden)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, map[string]any{"pid": p.Pid, "signal": signal})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// processesHandler searches the process table on demand, without waiting
+// for or touching the snapshot stream. ?q= matches a substring of the name or
+// command line and ?limit= caps the result, defaulting to -top; the
+// parameters of /ws filter and sort as usual.
+func (app *application) processesHandler(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+
+	query, err := parseProcessQuery(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.search = strings.ToLower(v.Get("q"))
+
+	if l := v.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			http.Error(w, fmt.Sprintf("invalid limit %q: must be a positive number", l), http.StatusBadRequest)
+			return
+		}
+		query.limit = limit
+	}
+
+	ctx := r.Context()
+
+	vm, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cpuCount, err := cpu.CountsWithContext(ctx, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	processes, _, err := app.listProcesses(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Redacted before searching, so a secret cannot be guessed a character
+	// at a time through ?q=
+	infos := app.hideProcesses(collectProcesses(ctx, processes, vm.Total))
+	app.redactCmdlines(infos)
+
+	// CPU usage since the collector's latest snapshot, as /ws reports it,
+	// rather than the lifetime average
+	if latest := app.collector.peek(); latest != nil {
+		cpuSince(infos, latest.Processes, time.Since(latest.processesAt))
+	}
+
+	selected := app.selectProcesses(infos, query)
+	truncateCmdlines(selected, app.config.cmdlineMax)
+	roundProcesses(selected, app.config.round)
+	query.scaleCPU(selected, cpuCount, app.config.round)
+
+	err = app.writeJSON(w, http.StatusOK, selected)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// cpuSince sets the CPU usage of processes from the readings in prev, taken
+// elapsed earlier. Processes missing from prev stay CPUPreliminary.
+func cpuSince(processes, prev []ProcessInfo, elapsed time.Duration) {
+	earlier := make(map[int32]ProcessInfo, len(prev))
+	for _, p := range prev {
+		earlier[p.PID] = p
+	}
+	for i := range processes {
+		proc := &processes[i]
+		p, ok := earlier[proc.PID]
+		if !ok || p.CreateTime != proc.CreateTime {
+			continue
+		}
+		proc.CPUPercent, proc.CPUPreliminary = processCPUPercent(proc.CPUTimeSeconds, p.CPUTimeSeconds, elapsed), false
+	}
+}
+
+// processHandler returns everything known about one process, including
+// details too costly to read for every process in a snapshot. Details that
+// cannot be read, usually for lack of permission, are left empty and listed
+// in Errors. Environment variables often hold secrets, so they are only
+// included when Basic Auth is enabled.
+func (app *application) processHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := findProcess(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	v, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	info, ok := processInfo(ctx, p, v.Total)
+	if !ok {
+		http.Error(w, "process not found", http.StatusNotFound)
+		return
+	}
+
+	info.Cmdline = app.redact(info.Cmdline)
+
+	// CPU usage since the collector's latest snapshot, as in the process
+	// list; a process it has no reading of stays CPUPreliminary
+	one := []ProcessInfo{info}
+	if latest := app.collector.peek(); latest != nil {
+		cpuSince(one, latest.Processes, time.Since(latest.processesAt))
+	}
+	roundProcesses(one, app.config.round)
+	info = one[0]
+
+	details := ProcessDetails{
+		ProcessInfo: info,
+		Connections: []ProcessConnection{},
+	}
+	errs := make(map[string]string)
+
+	details.Exe, err = p.ExeWithContext(ctx)
+	if err != nil {
+		errs["exe"] = err.Error()
+	}
+
+	details.Cwd, err = p.CwdWithContext(ctx)
+	if err != nil {
+		errs["cwd"] = err.Error()
+	}
+
+	if app.basicAuthEnabled() {
+		details.Environ, err = p.EnvironWithContext(ctx)
+		if err != nil {
+			errs["environ"] = err.Error()
+		}
+		for i, v := range details.Environ {
+			details.Environ[i] = app.redact(v)
+		}
+	}
+
+	connections, err := p.ConnectionsWithContext(ctx)
+	if err != nil {
+		errs["connections"] = err.Error()
+	}
+	for _, c := range connections {
+		details.Connections = append(details.Connections, ProcessConnection{
+			FD:         c.Fd,
+			Protocol:   socketProtocol(c.Family, c.Type),
+			LocalAddr:  formatAddr(c.Laddr),
+			RemoteAddr: formatAddr(c.Raddr),
+			Status:     c.Status,
+		})
+	}
+
+	if len(errs) > 0 {
+		details.Errors = errs
+	}
+
+	err = app.writeJSON(w, http.StatusOK, details)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// socketProtocol names a socket the way netstat does, e.g. tcp6 or udp
+func socketProtocol(family, sockType uint32) string {
+	var name string
+	switch sockType {
+	case syscall.SOCK_STREAM:
+		name = "tcp"
+	case syscall.SOCK_DGRAM:
+		name = "udp"
+	default:
+		name = "other"
+	}
+
+	switch family {
+	case syscall.AF_INET6:
+		return name + "6"
+	case syscall.AF_UNIX:
+		return "unix"
+	}
+	return name
+}
+
+// formatAddr renders an address as host:port, or empty when there is no
+// port, as for the remote end of a listening socket
+func formatAddr(a psnet.Addr) string {
+	if a.Port == 0 {
+		return ""
+	}
+	return net.JoinHostPort(a.IP, strconv.FormatUint(uint64(a.Port), 10))
+}
+
+// cmdlineHandler returns the full command line of a process, for clients
+// that were sent a truncated one
+func (app *application) cmdlineHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := findProcess(w, r)
+	if !ok {
+		return
+	}
+
+	cmdline, err := p.CmdlineWithContext(r.Context())
+	if err != nil {
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			http.Error(w, "process not found", http.StatusNotFound)
+		case errors.Is(err, os.ErrPermission):
+			http.Error(w, "permission denied", http.StatusForbidden)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, map[string]any{"pid": p.Pid, "cmdline": app.redact(cmdline)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// childrenHandler lists the PIDs of a process's direct children, so clients
+// can build a process tree
+func (app *application) childrenHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := findProcess(w, r)
+	if !ok {
+		return
+	}
+
+	children, err := p.ChildrenWithContext(r.Context())
+	if err != nil {
+		if errors.Is(err, process.ErrorProcessNotRunning) {
+			http.Error(w, "process not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pids := make([]int32, 0, len(children))
+	for _, child := range children {
+		pids = append(pids, child.Pid)
+	}
+	slices.Sort(pids)
+
+	err = app.writeJSON(w, http.StatusOK, map[string]any{"pid": p.Pid, "children": pids})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// findProcess looks up the process named by the {pid} path segment. When it
+// cannot, it replies with 400 or 404 and returns false.
+func findProcess(w http.ResponseWriter, r *http.Request) (*process.Process, bool) {
+	pid, err := strconv.ParseInt(r.PathValue("pid"), 10, 32)
+	if err != nil || pid <= 0 {
+		http.Error(w, "invalid pid", http.StatusBadRequest)
+		return nil, false
+	}
+
+	p, err := process.NewProcessWithContext(r.Context(), int32(pid))
+	if err != nil {
+		if errors.Is(err, process.ErrorProcessNotRunning) {
+			http.Error(w, "process not found", http.StatusNotFound)
+			return nil, false
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return p, true
+}
+
+type ProcessDetails struct {
+	ProcessInfo
+
+	// Path of the executable and the working directory
+	Exe string `json:"exe"`
+	Cwd string `json:"cwd"`
+
+	// KEY=value pairs; only sent when Basic Auth is enabled
+	Environ []string `json:"environ,omitempty"`
+
+	Connections []ProcessConnection `json:"connections"`
+
+	// Details that could not be read, keyed by field, with the reason
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+type ProcessConnection struct {
+	FD         uint32 `json:"fd"`
+	Protocol   string `json:"protocol"` // tcp, tcp6, udp, udp6 or unix
+	LocalAddr  string `json:"localAddr"`
+	RemoteAddr string `json:"remoteAddr"` // Empty for listening sockets
+	Status     string `json:"status"`     // TCP state, e.g. LISTEN or ESTABLISHED
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// defaultRedact is the -redact list used unless one is given
+const defaultRedact = "pass,token,secret"
+
+// keyName matches -redact entries that are treated as key names rather than
+// regular expressions
+var keyName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseRedact compiles a -redact list. A plain key name such as password
+// masks the value that follows any key containing it, case-insensitively,
+// in key=value, key: value and --key value form, so DB_PASSWORD=hunter2
+// becomes DB_PASSWORD=***. Any other entry is a regular expression whose
+// whole match is masked.
+//
+// Every expression starts with a capture group holding the text to keep,
+// empty for regular expressions, so all of them are applied the same way by
+// redact.
+func parseRedact(list []string) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, 0, len(list))
+	for _, entry := range list {
+		_, err := regexp.Compile(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", entry, err)
+		}
+
+		expr := "()(?:" + entry + ")"
+		if keyName.MatchString(entry) {
+			key := regexp.QuoteMeta(entry)
+			expr = `(?i)((?:[\w-]*` + key + `[\w-]*[=:]\s*)|(?:--?[\w-]*` + key + `[\w-]*\s+))[^\s"']+`
+		}
+
+		patterns = append(patterns, regexp.MustCompile(expr))
+	}
+	return patterns, nil
+}
+
+// redact masks every match of the -redact patterns in s with ***
+func (app *application) redact(s string) string {
+	for _, re := range app.config.redact {
+		s = re.ReplaceAllString(s, "${1}***")
+	}
+	return s
+}
+
+// redactCmdlines masks secrets in the command line of every process. It
+// must run before truncateCmdlines, so a secret cut in half is still
+// recognised.
+func (app *application) redactCmdlines(processes []ProcessInfo) {
+	for i := range processes {
+		processes[i].Cmdline = app.redact(processes[i].Cmdline)
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// remoteCommand prints, in order, the hostname, /proc/uptime,
+// /proc/loadavg, the number of online cores and /proc/meminfo. It needs
+// nothing but a POSIX shell on the remote host, so no agent has to be
+// installed there.
+const remoteCommand = "cat /proc/sys/kernel/hostname /proc/uptime /proc/loadavg && getconf _NPROCESSORS_ONLN && cat /proc/meminfo"
+
+// remoteHost is a Linux machine polled over SSH. Its poller writes it and
+// handlers read it, so access goes through the mutex.
+type remoteHost struct {
+	target string // user@host as given to ssh
+
+	mu            sync.Mutex
+	latest        *Resources
+	lastSeen      time.Time
+	lastError     string
+	lastErrorTime time.Time
+}
+
+type HostStatus struct {
+	// "local" for this server, otherwise the -remote target
+	Name     string `json:"name"`
+	Hostname string `json:"hostname"`
+	Local    bool   `json:"local"`
+
+	// When the remote host last answered, and the last failure to reach
+	// it; Reachable is false until it first answers or after a failure
+	Reachable     bool      `json:"reachable"`
+	LastSeen      time.Time `json:"lastSeen,omitzero"`
+	LastError     string    `json:"lastError,omitempty"`
+	LastErrorTime time.Time `json:"lastErrorTime,omitzero"`
+}
+
+// runRemote polls one remote host every -remote-interval until the server
+// shuts down
+func (app *application) runRemote(h *remoteHost) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-app.shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(app.config.remoteInterval)
+	defer ticker.Stop()
+
+	for {
+		rs, err := collectRemote(ctx, h.target, app.config.remoteInterval)
+
+		h.mu.Lock()
+		if err != nil {
+			h.lastError, h.lastErrorTime = err.Error(), time.Now()
+		} else {
+			roundPercentages(rs, app.config.round)
+			h.latest, h.lastSeen = rs, time.Now()
+		}
+		h.mu.Unlock()
+
+		if err != nil && ctx.Err() == nil {
+			app.logger.Warn("polling remote host", "target", h.target, "error", err)
+		}
+
+		select {
+		case <-app.shutdown:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collectRemote runs remoteCommand over SSH and builds a snapshot from its
+// output. Only the hostname, uptime, core count, load, memory and swap are
+// filled in; the other sections are empty.
+func collectRemote(ctx context.Context, target string, timeout time.Duration) (*Resources, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+
+	// BatchMode makes ssh fail instead of prompting for a password or host
+	// key confirmation nobody is there to answer
+	out, err := exec.CommandContext(ctx, "ssh",
+		"-o", "BatchMode=yes",
+		"-o", "ConnectTimeout="+strconv.Itoa(max(1, int(timeout.Seconds()))),
+		"--", target, remoteCommand,
+	).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+
+	return parseRemote(string(out), start)
+}
+
+// parseRemote builds a snapshot from the output of remoteCommand
+func parseRemote(out string, start time.Time) (*Resources, error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) < 5 {
+		return nil, fmt.Errorf("unexpected output from remote host: %q", out)
+	}
+
+	rs := &Resources{
+		SchemaVersion:      schemaVersion,
+		Timestamp:          start,
+		Unit:               "bytes",
+		Hostname:           strings.TrimSpace(lines[0]),
+		CPU:                CPU{PerCore: []float64{}},
+		CPUFrequency:       []float64{},
+		Partitions:         []DiskPartition{},
+		DiskIO:             []DiskIOStat{},
+		Network:            []NetInterface{},
+		NetworkConnections: map[string]int{},
+		Sensors:            []TemperatureStat{},
+		Fans:               []FanStat{},
+		GPUs:               []GPUStat{},
+		Processes:          []ProcessInfo{},
+	}
+
+	if rs.Hostname == "" {
+		return nil, fmt.Errorf("unexpected output from remote host: no hostname")
+	}
+
+	// /proc/uptime: seconds since boot, then idle seconds
+	uptime, err := parseRemoteFloat(firstField(lines[1]))
+	if err != nil {
+		return nil, fmt.Errorf("parsing uptime: %w", err)
+	}
+	rs.Uptime = uint64(uptime)
+	rs.UptimeHuman = formatUptime(rs.Uptime)
+	rs.BootTime = uint64(start.Unix()) - rs.Uptime
+
+	// /proc/loadavg: the three averages, then running/total tasks and the
+	// last PID
+	fields := strings.Fields(lines[2])
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("parsing load average: %q", lines[2])
+	}
+	loads := make([]float64, 3)
+	for i := range loads {
+		loads[i], err = parseRemoteFloat(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("parsing load average: %w", err)
+		}
+	}
+	rs.LoadAverage = LoadAverage{Load1: loads[0], Load5: loads[1], Load15: loads[2]}
+
+	rs.CPUCount, err = strconv.Atoi(strings.TrimSpace(lines[3]))
+	if err != nil {
+		return nil, fmt.Errorf("parsing core count: %w", err)
+	}
+	if rs.CPUCount < 1 {
+		return nil, fmt.Errorf("parsing core count: %d", rs.CPUCount)
+	}
+	cores := float64(rs.CPUCount)
+	rs.LoadAverage.Load1PerCore = loads[0] / cores
+	rs.LoadAverage.Load5PerCore = loads[1] / cores
+	rs.LoadAverage.Load15PerCore = loads[2] / cores
+
+	rs.Memory, rs.Swap, err = parseMeminfo(lines[4:])
+	if err != nil {
+		return nil, fmt.Errorf("parsing meminfo: %w", err)
+	}
+	return rs, nil
+}
+
+// parseRemoteFloat parses a non-negative number, rejecting the NaN and
+// infinities strconv.ParseFloat accepts
+func parseRemoteFloat(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) || f < 0 {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return f, nil
+}
+
+// meminfoRequired are the /proc/meminfo fields every kernel prints. Output
+// missing any of them was cut short, and would otherwise read as zeros.
+var meminfoRequired = []string{"MemTotal", "MemFree", "SwapTotal", "SwapFree"}
+
+// parseMeminfo reads /proc/meminfo lines, working out used memory the same
+// way gopsutil does for the local host so the numbers compare
+func parseMeminfo(lines []string) (Memory, Swap, error) {
+	kb := make(map[string]float64)
+	s := bufio.NewScanner(strings.NewReader(strings.Join(lines, "\n")))
+	for s.Scan() {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			return Memory{}, Swap{}, fmt.Errorf("unexpected line %q", s.Text())
+		}
+		n, err := strconv.ParseUint(firstField(value), 10, 64)
+		if err != nil {
+			return Memory{}, Swap{}, fmt.Errorf("unexpected line %q", s.Text())
+		}
+		kb[key] = float64(n) * 1024
+	}
+	for _, key := range meminfoRequired {
+		if _, ok := kb[key]; !ok {
+			return Memory{}, Swap{}, fmt.Errorf("no %s", key)
+		}
+	}
+	if kb["MemTotal"] == 0 {
+		return Memory{}, Swap{}, fmt.Errorf("MemTotal is 0")
+	}
+
+	m := Memory{
+		Total:        kb["MemTotal"],
+		Free:         kb["MemFree"],
+		Available:    kb["MemAvailable"],
+		Cached:       kb["Cached"] + kb["SReclaimable"],
+		Buffers:      kb["Buffers"],
+		Shared:       kb["Shmem"],
+		SReclaimable: kb["SReclaimable"],
+	}
+	if m.Available == 0 {
+		// Kernels before 3.14 have no MemAvailable
+		m.Available = m.Free + m.Cached
+	}
+	if m.Total > m.Available {
+		m.Used = m.Total - m.Available
+	}
+	if m.Total > 0 {
+		m.UsedPercent = 100 * m.Used / m.Total
+	}
+
+	sw := Swap{Total: kb["SwapTotal"], Free: kb["SwapFree"]}
+	if sw.Total > sw.Free {
+		sw.Used = sw.Total - sw.Free
+	}
+	if sw.Total > 0 {
+		sw.UsedPercent = 100 * sw.Used / sw.Total
+	}
+
+	return m, sw, nil
+}
+
+func firstField(s string) string {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// findRemote returns the remote host polled as target, or nil
+func (app *application) findRemote(target string) *remoteHost {
+	for _, h := range app.remotes {
+		if h.target == target {
+			return h
+		}
+	}
+	return nil
+}
+
+// hostsHandler lists this host followed by every -remote target
+func (app *application) hostsHandler(w http.ResponseWriter, r *http.Request) {
+	hosts := make([]HostStatus, 0, len(app.remotes)+1)
+
+	local := HostStatus{Name: "local", Local: true}
+	if rs := app.collector.peek(); rs != nil {
+		local.Hostname = rs.Hostname
+		local.Reachable = true
+		local.LastSeen = rs.Timestamp
+	}
+	hosts = append(hosts, local)
+
+	for _, h := range app.remotes {
+		h.mu.Lock()
+		status := HostStatus{
+			Name:          h.target,
+			Reachable:     h.latest != nil && !h.lastErrorTime.After(h.lastSeen),
+			LastSeen:      h.lastSeen,
+			LastError:     h.lastError,
+			LastErrorTime: h.lastErrorTime,
+		}
+		if h.latest != nil {
+			status.Hostname = h.latest.Hostname
+		}
+		h.mu.Unlock()
+
+		hosts = append(hosts, status)
+	}
+
+	err := app.writeJSON(w, http.StatusOK, hosts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// remoteSnapshotHandler returns the latest snapshot of one -remote target
+func (app *application) remoteSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	h := app.findRemote(r.PathValue("target"))
+	if h == nil {
+		http.Error(w, "unknown host", http.StatusNotFound)
+		return
+	}
+
+	h.mu.Lock()
+	rs, lastError := h.latest, h.lastError
+	h.mu.Unlock()
+
+	if rs == nil {
+		msg := "no snapshot yet"
+		if lastError != "" {
+			msg += ": " + lastError
+		}
+		http.Error(w, msg, http.StatusServiceUnavailable)
+		return
+	}
+
+	// The percentages were rounded when the snapshot was taken; sizes are
+	// converted on a copy, since the poller's snapshot is shared
+	view := *rs
+	convertSizes(&view, app.config.memUnit, app.config.round)
+
+	err := app.writeJSON(w, http.StatusOK, view)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
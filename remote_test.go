@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMeminfo = `MemTotal:       16000000 kB
+MemFree:         2000000 kB
+MemAvailable:    8000000 kB
+Buffers:          100000 kB
+Cached:          5000000 kB
+Shmem:            200000 kB
+SReclaimable:     300000 kB
+SwapTotal:       4000000 kB
+SwapFree:        3000000 kB
+HugePages_Total:       0`
+
+const testRemoteOutput = "web1\n" +
+	"3600.50 7000.00\n" +
+	"0.50 1.00 2.00 1/200 12345\n" +
+	"4\n" +
+	testMeminfo + "\n"
+
+func TestParseRemote(t *testing.T) {
+	start := time.Unix(1_000_000, 0)
+
+	rs, err := parseRemote(testRemoteOutput, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rs.Hostname != "web1" {
+		t.Errorf("Hostname = %q, want web1", rs.Hostname)
+	}
+	if rs.Uptime != 3600 || rs.BootTime != 1_000_000-3600 {
+		t.Errorf("Uptime = %d, BootTime = %d", rs.Uptime, rs.BootTime)
+	}
+	if rs.CPUCount != 4 {
+		t.Errorf("CPUCount = %d, want 4", rs.CPUCount)
+	}
+	if rs.LoadAverage.Load15 != 2 || rs.LoadAverage.Load15PerCore != 0.5 {
+		t.Errorf("LoadAverage = %+v", rs.LoadAverage)
+	}
+	if rs.Memory.Total != 16000000*1024 || rs.Memory.Used != 8000000*1024 || rs.Memory.UsedPercent != 50 {
+		t.Errorf("Memory = %+v", rs.Memory)
+	}
+	if rs.Swap.Used != 1000000*1024 || rs.Swap.UsedPercent != 25 {
+		t.Errorf("Swap = %+v", rs.Swap)
+	}
+}
+
+func TestParseRemoteRejectsBadOutput(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(testRemoteOutput), "\n")
+	replace := func(i int, line string) string {
+		changed := append([]string(nil), lines...)
+		changed[i] = line
+		return strings.Join(changed, "\n")
+	}
+
+	tests := []struct {
+		name string
+		out  string
+	}{
+		{name: "empty", out: ""},
+		{name: "truncated before meminfo", out: strings.Join(lines[:4], "\n")},
+		{name: "truncated meminfo", out: strings.Join(lines[:6], "\n")},
+		{name: "garbled uptime", out: replace(1, "up a while")},
+		{name: "NaN uptime", out: replace(1, "NaN 0")},
+		{name: "short load average", out: replace(2, "0.50 1.00")},
+		{name: "negative load average", out: replace(2, "-1 1.00 2.00 1/200 12345")},
+		{name: "garbled core count", out: replace(3, "four")},
+		{name: "zero cores", out: replace(3, "0")},
+		{name: "garbled meminfo line", out: replace(4, "MemTotal: lots kB")},
+		{name: "meminfo line without colon", out: replace(5, "MemFree 2000000 kB")},
+		{name: "error message instead of output", out: "cat: /proc/uptime: No such file or directory\n\n\n\n\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := parseRemote(tt.out, time.Now())
+			if err == nil {
+				t.Errorf("parseRemote succeeded with %+v, want an error", rs)
+			}
+		})
+	}
+}
+
+func TestParseMeminfo(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantErr bool
+	}{
+		{name: "complete", in: testMeminfo},
+		{name: "empty", in: "", wantErr: true},
+		{name: "no swap lines", in: strings.Join(strings.Split(testMeminfo, "\n")[:7], "\n"), wantErr: true},
+		{name: "zero total", in: strings.Replace(testMeminfo, "16000000", "0", 1), wantErr: true},
+		{name: "garbled", in: "\x00\x01binary junk", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _, err := parseMeminfo(strings.Split(tt.in, "\n"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMeminfo error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && m.Total == 0 {
+				t.Error("parseMeminfo returned zeroed memory")
+			}
+		})
+	}
+}
+
+func TestParseMeminfoWithoutMemAvailable(t *testing.T) {
+	var lines []string
+	for _, line := range strings.Split(testMeminfo, "\n") {
+		if !strings.HasPrefix(line, "MemAvailable:") {
+			lines = append(lines, line)
+		}
+	}
+
+	m, _, err := parseMeminfo(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Free plus Cached, which includes SReclaimable
+	if want := float64(2000000+5000000+300000) * 1024; m.Available != want {
+		t.Errorf("Available = %v, want %v", m.Available, want)
+	}
+}
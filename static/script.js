@@ -1,6 +1,12 @@
+// Server configuration injected into index.html
+const basePath = document.body.dataset.basePath || "";
+const wsPath = document.body.dataset.wsPath || `${basePath}/ws`;
+const interval = Number(document.body.dataset.interval) || 1000;
+
 // Dynamic WebSocket URL construction
 const protocol = window.location.protocol === "https:" ? "wss:" : "ws:";
-const wsUrl = `${protocol}//${window.location.host}/ws`;
+// The dashboard reads camelCase fields whatever -json-case says
+const wsUrl = `${protocol}//${window.location.host}${wsPath}?json-case=camel`;
 const ws = new WebSocket(wsUrl);
 
 const statusEl = document.getElementById("connection-status");
@@ -21,7 +27,7 @@ const themeStylesheet = document.getElementById("theme-style");
 
 // Load saved theme from localStorage
 const savedTheme = localStorage.getItem("res_mon-theme") || "terminal";
-themeStylesheet.href = `/static/styles/${savedTheme}.css`;
+themeStylesheet.href = `${basePath}/static/styles/${savedTheme}.css`;
 
 // Toggle dropdown
 themeBtn.addEventListener("click", (e) => {
@@ -39,13 +45,18 @@ themeOptions.forEach((option) => {
   option.addEventListener("click", (e) => {
     e.stopPropagation();
     const theme = option.dataset.theme;
-    themeStylesheet.href = `/static/styles/${theme}.css`;
+    themeStylesheet.href = `${basePath}/static/styles/${theme}.css`;
     localStorage.setItem("res_mon-theme", theme);
     themeMenu.classList.remove("show");
   });
 });
 
-function formatBytes(bytes) {
+// Sizes arrive in the unit set by -mem-unit
+const unitBytes = { bytes: 1, MB: 1024 ** 2, GB: 1024 ** 3 };
+let sizeUnit = "bytes";
+
+function formatBytes(size) {
+  const bytes = size * (unitBytes[sizeUnit] || 1);
   return (bytes / 1024 ** 3).toFixed(2);
 }
 
@@ -207,7 +218,11 @@ function updateProcessesDisplay(processes) {
       const cpuCell = document.createElement("td");
       cpuCell.textContent = proc.cpuPercent.toFixed(1) + "%";
       cpuCell.className = "process-cpu";
-      if (proc.cpuPercent > 50) {
+      if (proc.cpuPreliminary) {
+        // Lifetime average until the server has a second reading
+        cpuCell.style.opacity = "0.5";
+        cpuCell.title = "Average since the process started";
+      } else if (proc.cpuPercent > 50) {
         cpuCell.classList.add("high-usage");
       }
       row.appendChild(cpuCell);
@@ -215,6 +230,7 @@ function updateProcessesDisplay(processes) {
       // Memory
       const memCell = document.createElement("td");
       memCell.textContent = proc.memoryMB.toFixed(1) + " MB";
+      memCell.title = "Virtual: " + proc.virtualMB.toFixed(1) + " MB";
       memCell.className = "process-memory";
       row.appendChild(memCell);
 
@@ -222,6 +238,10 @@ function updateProcessesDisplay(processes) {
       const statusCell = document.createElement("td");
       statusCell.textContent = proc.status;
       statusCell.className = "process-status";
+      if (proc.zombie) {
+        statusCell.style.color = "#e5484d";
+        statusCell.title = "Exited, but its parent has not reaped it";
+      }
       row.appendChild(statusCell);
 
       // User
@@ -251,7 +271,23 @@ ws.onopen = function (event) {
   logMessage("Connected to server");
 };
 
+// Flag the data as stale when a few snapshots in a row have not arrived
+let staleTimer = null;
+function resetStaleTimer() {
+  clearTimeout(staleTimer);
+  staleTimer = setTimeout(() => {
+    statusTextEl.textContent = "Stale";
+    statusEl.className = "status disconnected";
+  }, interval * 3);
+}
+
 ws.onmessage = function (event) {
+  if (statusTextEl.textContent === "Stale") {
+    statusTextEl.textContent = "Connected";
+    statusEl.className = "status connected";
+  }
+  resetStaleTimer();
+
   try {
     const data = JSON.parse(event.data);
 
@@ -261,6 +297,10 @@ ws.onmessage = function (event) {
       return;
     }
 
+    if (data.unit) {
+      sizeUnit = data.unit;
+    }
+
     if (data.hostname && data.uptime !== undefined) {
       updateSystemInfo(data.hostname, data.uptime);
     }
@@ -269,8 +309,8 @@ ws.onmessage = function (event) {
       updateMemoryDisplay(data.memory);
     }
 
-    if (data.load_average) {
-      updateLoadDisplay(data.load_average);
+    if (data.loadAverage) {
+      updateLoadDisplay(data.loadAverage);
     }
 
     if (data.partitions) {
@@ -285,11 +325,27 @@ ws.onmessage = function (event) {
   }
 };
 
+// closeReasonText returns the human-readable part of a close reason. A
+// server asking clients to back off sends JSON with a retryAfterMs hint.
+function closeReasonText(reason) {
+  try {
+    const parsed = JSON.parse(reason);
+    if (parsed && parsed.reason) {
+      const seconds = Math.ceil(parsed.retryAfterMs / 1000);
+      return `${parsed.reason} (retry in ${seconds}s)`;
+    }
+  } catch (e) {
+    // Plain text reason
+  }
+  return reason;
+}
+
 ws.onclose = function (event) {
+  clearTimeout(staleTimer);
   statusTextEl.textContent = "Disconnected";
   statusEl.className = "status disconnected";
   if (event.reason) {
-    logMessage("Disconnected: " + event.reason, "error");
+    logMessage("Disconnected: " + closeReasonText(event.reason), "error");
   } else {
     logMessage("Disconnected from server", "error");
   }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// statsdMaxPacket keeps each datagram within a typical Ethernet MTU
+const statsdMaxPacket = 1432
+
+// runStatsD pushes every snapshot from the collector to the StatsD server at
+// -statsd-addr as gauges, until the server shuts down. It runs whether or
+// not any dashboard is open.
+func (app *application) runStatsD() {
+	if app.config.statsdAddr == "" {
+		return
+	}
+
+	conn, err := net.Dial("udp", app.config.statsdAddr)
+	if err != nil {
+		app.logger.Error("connecting to statsd", "addr", app.config.statsdAddr, "error", err)
+		return
+	}
+	defer conn.Close()
+
+	updates, _ := app.collector.subscribe()
+	defer app.collector.unsubscribe(updates)
+
+	for {
+		select {
+		case <-app.shutdown:
+			return
+		case rs := <-updates:
+			for _, packet := range statsdPackets(statsdGauges(rs)) {
+				// UDP gives no delivery guarantee anyway; a server that is
+				// down only costs the samples sent meanwhile
+				_, err := conn.Write(packet)
+				if err != nil {
+					app.logger.Debug("sending to statsd", "addr", app.config.statsdAddr, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// statsdGauges renders the gauges for a snapshot, one "name:value|g" line
+// each
+func statsdGauges(rs *Resources) []string {
+	gauge := func(name string, value float64) string {
+		return "res_mon." + name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|g"
+	}
+
+	lines := []string{
+		gauge("memory.used_percent", rs.Memory.UsedPercent),
+		gauge("memory.used_bytes", float64(rs.Memory.Used)),
+		gauge("swap.used_percent", rs.Swap.UsedPercent),
+		gauge("load1", rs.LoadAverage.Load1),
+		gauge("load5", rs.LoadAverage.Load5),
+		gauge("load15", rs.LoadAverage.Load15),
+		gauge("cpu.used_percent", rs.CPU.UsedPercent),
+	}
+	for _, p := range rs.Partitions {
+		mount := statsdName(p.Mountpoint)
+		lines = append(lines,
+			gauge("disk."+mount+".used_percent", p.UsedPercent),
+			gauge("disk."+mount+".used_bytes", float64(p.Used)),
+		)
+	}
+	return lines
+}
+
+// statsdName turns a mountpoint into a metric name segment: / becomes root
+// and /var/lib becomes var_lib
+func statsdName(mountpoint string) string {
+	name := strings.Trim(mountpoint, "/")
+	if name == "" {
+		return "root"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', '.', ':', '|', '@', ' ':
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// statsdPackets joins lines into as few datagrams as fit statsdMaxPacket
+func statsdPackets(lines []string) [][]byte {
+	var packets [][]byte
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if buf.Len() > 0 && buf.Len()+1+len(line) > statsdMaxPacket {
+			packets = append(packets, bytes.Clone(buf.Bytes()))
+			buf.Reset()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 {
+		packets = append(packets, buf.Bytes())
+	}
+	return packets
+}
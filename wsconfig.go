@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// configGrace is how long a new WebSocket connection waits for a config
+// message before streaming with its query parameters alone
+const configGrace = 200 * time.Millisecond
+
+// connConfig is what one WebSocket client asked for: which processes to
+// send and how often
+type connConfig struct {
+	query processQuery
+
+	// Minimum time between snapshots; 0 sends every one
+	interval time.Duration
+
+	// Naming of JSON fields, camel or snake; -json-case by default
+	jsonCase string
+
+	// Encoding of snapshots: json in text messages, or msgpack in binary
+	// messages
+	format string
+}
+
+// parseConnConfig reads the process query parameters, plus ?interval=, a
+// duration no shorter than -interval, ?top=, which can lower but not raise
+// -top, ?json-case=, which overrides -json-case, and ?format=, json or msgpack
+func (app *application) parseConnConfig(v url.Values) (connConfig, error) {
+	q, err := parseProcessQuery(v)
+	if err != nil {
+		return connConfig{}, err
+	}
+	cfg := connConfig{query: q, jsonCase: app.config.jsonCase, format: "json"}
+
+	switch jsonCase := v.Get("json-case"); jsonCase {
+	case "":
+	case "camel", "snake":
+		cfg.jsonCase = jsonCase
+	default:
+		return connConfig{}, fmt.Errorf("invalid json-case %q: must be camel or snake", jsonCase)
+	}
+
+	switch format := v.Get("format"); format {
+	case "":
+	case "json", "msgpack":
+		cfg.format = format
+	default:
+		return connConfig{}, fmt.Errorf("invalid format %q: must be json or msgpack", format)
+	}
+
+	if s := v.Get("interval"); s != "" {
+		interval, err := time.ParseDuration(s)
+		if err != nil || interval < app.config.interval {
+			return connConfig{}, fmt.Errorf("invalid interval %q: must be a duration of at least %s", s, app.config.interval)
+		}
+		cfg.interval = interval
+	}
+
+	if s := v.Get("top"); s != "" {
+		top, err := strconv.Atoi(s)
+		if err != nil || top < 1 {
+			return connConfig{}, fmt.Errorf("invalid top %q: must be a positive number", s)
+		}
+		if app.config.topN > 0 {
+			top = min(top, app.config.topN)
+		}
+		cfg.query.limit = top
+	}
+
+	return cfg, nil
+}
+
+// configMessageValues applies a config message from a client on top of its
+// query parameters. The message is a JSON object keyed like the query
+// parameters, e.g. {"sort": "mem", "top": 20, "status": ["R", "D"]}.
+func configMessageValues(msg []byte, query url.Values) (url.Values, error) {
+	var fields map[string]any
+	err := json.Unmarshal(msg, &fields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config message: %w", err)
+	}
+
+	v := maps.Clone(query)
+	if v == nil {
+		v = url.Values{}
+	}
+	for key, value := range fields {
+		v.Set(key, configValueString(value))
+	}
+	return v, nil
+}
+
+// due reports whether a snapshot taken at next should be sent to a client
+// whose previous snapshot was taken at last. Snapshots arrive every
+// -interval, give or take, so half of that is allowed as slack.
+func (c connConfig) due(last, next time.Time, serverInterval time.Duration) bool {
+	return c.interval == 0 || last.IsZero() || next.Sub(last) >= c.interval-serverInterval/2
+}